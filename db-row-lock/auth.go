@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// adminToken guards the /admin routes; set from ADMIN_TOKEN in main. When
+// it is empty every admin request is refused.
+var adminToken []byte
+
+// bearerToken returns the token of an "Authorization: Bearer <token>"
+// header, or "" if there is none.
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// requireAdmin reports whether the request carries the admin token, and
+// writes a 401 response if it does not.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := bearerToken(r)
+	if len(adminToken) == 0 || token == "" || subtle.ConstantTimeCompare([]byte(token), adminToken) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Missing or invalid admin token")
+		return false
+	}
+	return true
+}
+
+// checkOrderOwner fails with ORDER_OWNER_MISMATCH unless o belongs to
+// userID.
+func checkOrderOwner(o *order, userID string) error {
+	if !strings.EqualFold(o.UserID, userID) {
+		return &requestError{http.StatusForbidden, "ORDER_OWNER_MISMATCH", "Order belongs to another user"}
+	}
+	return nil
+}
+
+// scannerTokens maps the token of each door scanner to its name; set from
+// SCANNER_TOKENS ("gate-1=<token>,gate-2=<token>") in main. With none set
+// every check-in is refused.
+var scannerTokens map[string]string
+
+// parseScannerTokens reads a SCANNER_TOKENS value.
+func parseScannerTokens(s string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("scanner %q: want name=token", pair)
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, fmt.Errorf("scanner %s: token is already used by %s", name, tokens[token])
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// requireScanner returns the name of the scanner whose token the request
+// carries, or writes a 401 response.
+func requireScanner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := bearerToken(r)
+	name := ""
+	for known, scanner := range scannerTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			name = scanner
+		}
+	}
+	if token == "" || name == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scanner"`)
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Missing or invalid scanner token")
+		return "", false
+	}
+	return name, true
+}
@@ -68,6 +68,12 @@ func expirePendingReservations() {
 		if err != nil {
 			log.Println("Error committing transaction:", err)
 		}
+
+		// Drop idempotency keys older than the replay window of the API (24h)
+		_, err = db.Exec(`DELETE FROM idempotency_keys WHERE created_at < $1`, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			log.Println("Error deleting old idempotency keys:", err)
+		}
 	}
 }
 
@@ -1,3 +1,8 @@
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS issued_tickets;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
 DROP TABLE IF EXISTS reservations;
 DROP TABLE IF EXISTS tickets;
 DROP TABLE IF EXISTS events;
@@ -15,6 +20,7 @@ CREATE TABLE tickets (
     id UUID PRIMARY KEY,
     event_id UUID REFERENCES events(id),
     seat_number TEXT NOT NULL,
+    price_cents BIGINT NOT NULL DEFAULT 0 CHECK (price_cents >= 0),
     status TEXT NOT NULL CHECK (status IN ('AVAILABLE', 'RESERVED', 'BOOKED'))
 );
 
@@ -27,18 +33,87 @@ CREATE TABLE reservations (
     status TEXT NOT NULL CHECK (status IN ('PENDING', 'CONFIRMED', 'CANCELLED'))
 );
 
+CREATE INDEX reservations_user_id_idx ON reservations (user_id);
+
+-- Reservations checked out together, with the prices and fees at checkout
+CREATE TABLE orders (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('PENDING', 'PAID', 'CANCELLED', 'REFUNDED')),
+    payment_intent_id TEXT UNIQUE,
+    currency TEXT NOT NULL,
+    subtotal_cents BIGINT NOT NULL,
+    service_fee_cents BIGINT NOT NULL,
+    order_fee_cents BIGINT NOT NULL,
+    tax_cents BIGINT NOT NULL,
+    total_cents BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX orders_user_id_idx ON orders (user_id);
+
+-- A reservation belongs to at most one order
+CREATE TABLE order_items (
+    order_id UUID NOT NULL REFERENCES orders(id),
+    reservation_id UUID NOT NULL UNIQUE REFERENCES reservations(id),
+    ticket_id UUID NOT NULL REFERENCES tickets(id),
+    seat_number TEXT NOT NULL,
+    price_cents BIGINT NOT NULL,
+    service_fee_cents BIGINT NOT NULL,
+    PRIMARY KEY (order_id, reservation_id)
+);
+
+-- Responses of /reserve and /confirm calls made with an Idempotency-Key
+CREATE TABLE idempotency_keys (
+    endpoint TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash BYTEA NOT NULL,
+    response_code INTEGER,
+    response_body TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (endpoint, key)
+);
+
+-- What a guest shows at the door: one per seat of a paid order. The QR
+-- payload is signed from code and event_id, so it is not stored.
+CREATE TABLE issued_tickets (
+    code TEXT PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders(id),
+    reservation_id UUID NOT NULL UNIQUE REFERENCES reservations(id),
+    ticket_id UUID NOT NULL REFERENCES tickets(id),
+    event_id UUID NOT NULL REFERENCES events(id),
+    seat_number TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    issued_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    checked_in_at TIMESTAMP,
+    checked_in_by TEXT,
+    voided_at TIMESTAMP
+);
+
+CREATE INDEX issued_tickets_order_id_idx ON issued_tickets (order_id);
+
+-- Payment webhook events already handled; a redelivery is acknowledged
+-- and skipped
+CREATE TABLE webhook_events (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    intent_id TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
 -- Seed 1 event and 10 tickets
 INSERT INTO events (id, name, date, venue, total_seats, available_seats)
 VALUES ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'Concert', '2021-12-31 20:00:00', 'Venue', 10, 10);
 
-INSERT INTO tickets (id, event_id, seat_number, status)
-VALUES ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a12', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A1', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a13', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A2', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a14', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A3', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a15', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A4', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a16', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A5', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a17', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A6', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a18', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A7', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a19', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A8', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a20', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A9', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a21', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A10', 'AVAILABLE');
+INSERT INTO tickets (id, event_id, seat_number, price_cents, status)
+VALUES ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a12', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A1', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a13', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A2', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a14', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A3', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a15', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A4', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a16', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A5', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a17', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A6', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a18', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A7', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a19', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A8', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a20', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A9', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a21', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A10', 5000, 'AVAILABLE');
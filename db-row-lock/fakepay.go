@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeProvider is an in-memory PaymentProvider for local runs. Nothing is
+// charged: the client pays an intent with
+//
+//	POST /fakepay/intents/{id}/confirm {"client_secret": "...", "card": "4242424242424242"}
+//
+// and the card 4000000000000002 is declined, like the test cards of real
+// providers. Every change of an intent is sent as a signed webhook to
+// webhookURL, retried a few times. Intents are lost on restart.
+//
+// db-row-lock and distributed-lock each carry the same copy of this file on
+// purpose: they are independent modules with no shared code, so change both
+// copies together.
+type fakeProvider struct {
+	mu      sync.Mutex
+	intents map[string]*PaymentIntent
+	byKey   map[string]string // idempotency key -> intent ID
+
+	webhookURL    string
+	webhookSecret []byte
+	dropWebhooks  bool // lose every webhook, as if the network ate them
+	client        *http.Client
+}
+
+const fakeDeclinedCard = "4000000000000002"
+
+// fakeWebhookAttempts is how many times a webhook is sent before giving up.
+const fakeWebhookAttempts = 3
+
+func newFakeProvider(webhookURL string, webhookSecret []byte, dropWebhooks bool) *fakeProvider {
+	return &fakeProvider{
+		intents:       make(map[string]*PaymentIntent),
+		byKey:         make(map[string]string),
+		webhookURL:    webhookURL,
+		webhookSecret: webhookSecret,
+		dropWebhooks:  dropWebhooks,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *fakeProvider) CreateIntent(_ context.Context, amountCents int64, currency, idempotencyKey string, metadata map[string]string) (*PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, ok := p.byKey[idempotencyKey]; ok {
+		intent := *p.intents[id]
+		return &intent, nil
+	}
+	id := "pi_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	intent := &PaymentIntent{
+		ID:           id,
+		AmountCents:  amountCents,
+		Currency:     currency,
+		Status:       IntentRequiresPayment,
+		ClientSecret: id + "_secret_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Metadata:     metadata,
+	}
+	p.intents[id] = intent
+	if idempotencyKey != "" {
+		p.byKey[idempotencyKey] = id
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+func (p *fakeProvider) GetIntent(_ context.Context, id string) (*PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[id]
+	if !ok {
+		return nil, errIntentNotFound
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+func (p *fakeProvider) CancelIntent(_ context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[id]
+	if !ok {
+		return errIntentNotFound
+	}
+	switch intent.Status {
+	case IntentRequiresPayment:
+		intent.Status = IntentCanceled
+		p.notify(EventIntentCanceled, intent)
+	case IntentSucceeded, IntentRefunded:
+		return errIntentSucceeded
+	}
+	return nil
+}
+
+func (p *fakeProvider) RefundIntent(_ context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[id]
+	if !ok {
+		return errIntentNotFound
+	}
+	switch intent.Status {
+	case IntentSucceeded:
+		intent.Status = IntentRefunded
+		p.notify(EventIntentRefunded, intent)
+	case IntentRefunded:
+	default:
+		return errIntentNotPaid
+	}
+	return nil
+}
+
+// confirmIntent -> POST /fakepay/intents/{id}/confirm
+// Pays an intent the way a client would on the provider's checkout page.
+func (p *fakeProvider) confirmIntent(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientSecret string `json:"client_secret"`
+		Card         string `json:"card"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[r.PathValue("id")]
+	if !ok || subtle.ConstantTimeCompare([]byte(req.ClientSecret), []byte(intent.ClientSecret)) != 1 {
+		writeError(w, http.StatusNotFound, "PAYMENT_INTENT_NOT_FOUND", "Payment intent not found")
+		return
+	}
+	if intent.Status != IntentRequiresPayment {
+		writeError(w, http.StatusConflict, "PAYMENT_INTENT_NOT_PAYABLE", "Payment intent is already "+string(intent.Status))
+		return
+	}
+	if strings.ReplaceAll(req.Card, " ", "") == fakeDeclinedCard {
+		intent.Status = IntentFailed
+		intent.FailureReason = "card_declined"
+		p.notify(EventIntentFailed, intent)
+	} else {
+		intent.Status = IntentSucceeded
+		p.notify(EventIntentSucceeded, intent)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(intent)
+}
+
+// notify sends a webhook about intent in the background. p.mu must be held.
+func (p *fakeProvider) notify(eventType string, intent *PaymentIntent) {
+	data := *intent
+	data.ClientSecret = ""
+	event := webhookEvent{
+		ID:      "evt_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Type:    eventType,
+		Created: time.Now().Unix(),
+		Data:    &data,
+	}
+	if p.dropWebhooks {
+		log.Printf("fakepay: dropped webhook %s (%s %s)", event.ID, event.Type, data.ID)
+		return
+	}
+	go p.deliver(event)
+}
+
+func (p *fakeProvider) deliver(event webhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("fakepay: encode webhook %s: %v", event.ID, err)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err := p.post(body)
+		if err == nil {
+			return
+		}
+		log.Printf("fakepay: deliver webhook %s (attempt %d): %v", event.ID, attempt, err)
+		if attempt == fakeWebhookAttempts {
+			return
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func (p *fakeProvider) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, p.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, signWebhook(p.webhookSecret, time.Now().Unix(), body))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Clients retry /reserve and /confirm when a call times out. A request with
+// an Idempotency-Key header records its response in the same transaction as
+// the reservation, so a retry with the same key gets that response back
+// instead of reserving twice or failing with RESERVATION_NOT_PENDING.
+// /confirm stores the ID of its order instead, and a retry finishes the
+// checkout of that order again (see openPayment).
+//
+// The key row is inserted before any ticket is locked. A duplicate sent
+// while the first request is still running waits on that row and then
+// replays the committed response. Failed requests roll back their key, so
+// they can be retried for real.
+
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyTTL is how long a stored response is replayed. Older keys may
+// be reused and are deleted by the cronjob.
+const idempotencyTTL = 24 * time.Hour
+
+// storedResponse is the response of the first request made with a key.
+type storedResponse struct {
+	code int
+	body string
+}
+
+// claimIdempotencyKey records key for endpoint inside tx. It returns nil if
+// the request is new and should run, or the stored response to replay if the
+// key was already used for the same request body.
+func claimIdempotencyKey(tx *sql.Tx, endpoint, key string, body []byte) (*storedResponse, error) {
+	requestHash := sha256.Sum256(body)
+	res, err := tx.Exec(`
+		INSERT INTO idempotency_keys (endpoint, key, request_hash, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (endpoint, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, response_code = NULL, response_body = NULL, created_at = EXCLUDED.created_at
+		WHERE idempotency_keys.created_at < NOW() - make_interval(secs => $4)`,
+		endpoint, key, requestHash[:], idempotencyTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	var storedHash []byte
+	var stored storedResponse
+	err = tx.QueryRow(`SELECT request_hash, response_code, response_body FROM idempotency_keys WHERE endpoint = $1 AND key = $2`,
+		endpoint, key).Scan(&storedHash, &stored.code, &stored.body)
+	if err != nil {
+		return nil, err
+	}
+	if string(storedHash) != string(requestHash[:]) {
+		return nil, errIdempotencyKeyReused
+	}
+	return &stored, nil
+}
+
+var errIdempotencyKeyReused = errors.New("Idempotency-Key was already used for a different request")
+
+// saveIdempotentResponse stores the response for a key claimed in tx. It
+// must run before tx commits.
+func saveIdempotentResponse(tx *sql.Tx, endpoint, key string, code int, body string) error {
+	_, err := tx.Exec(`UPDATE idempotency_keys SET response_code = $3, response_body = $4 WHERE endpoint = $1 AND key = $2`,
+		endpoint, key, code, body)
+	return err
+}
+
+// replayResponse writes a stored response again.
+func replayResponse(w http.ResponseWriter, stored *storedResponse) {
+	w.Header().Set("Idempotent-Replayed", "true")
+	if json.Valid([]byte(stored.body)) {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(stored.code)
+	fmt.Fprint(w, stored.body)
+}
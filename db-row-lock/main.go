@@ -1,11 +1,16 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,23 +28,30 @@ func initDB() {
 }
 
 func reserveTicket(w http.ResponseWriter, r *http.Request) {
+	// Keep the raw body; it identifies the request for its Idempotency-Key
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+		return
+	}
+
 	// Decode JSON body into a map
 	var req map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
 		return
 	}
 
 	// Extract values from the map
-	ticketID, ok := req["ticket_id"].(string)
-	if !ok || ticketID == "" {
-		http.Error(w, "Invalid or missing ticket_id", http.StatusBadRequest)
+	ticketID, _ := req["ticket_id"].(string)
+	if _, err := uuid.Parse(ticketID); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing ticket_id")
 		return
 	}
-	userID, ok := req["user_id"].(string)
-	if !ok || userID == "" {
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+	userID, _ := req["user_id"].(string)
+	if _, err := uuid.Parse(userID); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing user_id")
 		return
 	}
 
@@ -49,23 +61,48 @@ func reserveTicket(w http.ResponseWriter, r *http.Request) {
 	// Start transaction
 	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 
+	// Replay the first response if this is a retry
+	idempotencyKey := r.Header.Get(idempotencyHeader)
+	if idempotencyKey != "" {
+		stored, err := claimIdempotencyKey(tx, "/reserve", idempotencyKey, body)
+		if err == errIdempotencyKeyReused {
+			tx.Rollback()
+			writeError(w, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", err.Error())
+			return
+		}
+		if err != nil {
+			tx.Rollback()
+			internalError(w, err)
+			return
+		}
+		if stored != nil {
+			tx.Rollback()
+			replayResponse(w, stored)
+			return
+		}
+	}
+
 	// Lock the ticket row
 	var ticketStatus string
 	err = tx.QueryRow(`SELECT status FROM tickets WHERE id = $1 FOR UPDATE`, ticketID).Scan(&ticketStatus)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		writeError(w, http.StatusNotFound, "TICKET_NOT_FOUND", "Ticket not found")
+		return
+	}
 	if err != nil {
-		fmt.Printf("Error querying ticket: %s\n", err)
 		tx.Rollback()
-		http.Error(w, "Ticket not found", http.StatusNotFound)
+		internalError(w, err)
 		return
 	}
 
 	if ticketStatus != "AVAILABLE" {
 		tx.Rollback()
-		http.Error(w, "Ticket is not available", http.StatusConflict)
+		writeError(w, http.StatusConflict, "TICKET_NOT_AVAILABLE", "Ticket is not available")
 		return
 	}
 
@@ -73,7 +110,7 @@ func reserveTicket(w http.ResponseWriter, r *http.Request) {
 	_, err = tx.Exec(`UPDATE tickets SET status = 'RESERVED' WHERE id = $1`, ticketID)
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 
@@ -81,78 +118,349 @@ func reserveTicket(w http.ResponseWriter, r *http.Request) {
 	_, err = tx.Exec(`INSERT INTO reservations (id, ticket_id, user_id, expires_at, status) VALUES ($1, $2, $3, $4, 'PENDING')`, reservationID, ticketID, userID, expiresAt)
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 
+	response := fmt.Sprintf("Reservation ID: %s, Expires At: %s", reservationID, expiresAt)
+
+	if idempotencyKey != "" {
+		err = saveIdempotentResponse(tx, "/reserve", idempotencyKey, http.StatusOK, response)
+		if err != nil {
+			tx.Rollback()
+			internalError(w, err)
+			return
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 
-	fmt.Fprintf(w, "Reservation ID: %s, Expires At: %s", reservationID, expiresAt)
+	fmt.Fprint(w, response)
 }
 
+// confirmReservation -> POST /confirm
+// Checks out one or more pending reservations of a user: creates a PENDING
+// order priced by the current rules and opens a payment intent for its
+// total. Returns the order with the intent's client secret; the tickets are
+// booked when the provider's webhook reports the payment succeeded.
 func confirmReservation(w http.ResponseWriter, r *http.Request) {
+	// Keep the raw body; it identifies the request for its Idempotency-Key
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+		return
+	}
 	var req map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
 		return
 	}
 
-	reservationID, ok := req["reservation_id"].(string)
-	if !ok || reservationID == "" {
-		http.Error(w, "Invalid or missing reservation_id", http.StatusBadRequest)
+	reservationIDs, msg := parseReservationIDs(req)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", msg)
 		return
 	}
 
 	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 
-	// Check if reservation is still valid
-	var status string
-	err = tx.QueryRow(`SELECT status FROM reservations WHERE id = $1 AND expires_at > $2`, reservationID, time.Now()).Scan(&status)
-	if err != nil || status != "PENDING" {
+	// Replay the first response if this is a retry. For /confirm the stored
+	// body is the order ID: the checkout is finished again from it, which
+	// hands out the same intent and client secret.
+	idempotencyKey := r.Header.Get(idempotencyHeader)
+	if idempotencyKey != "" {
+		stored, err := claimIdempotencyKey(tx, "/confirm", idempotencyKey, body)
+		if err == errIdempotencyKeyReused {
+			tx.Rollback()
+			writeError(w, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", err.Error())
+			return
+		}
+		if err != nil {
+			tx.Rollback()
+			internalError(w, err)
+			return
+		}
+		if stored != nil {
+			tx.Rollback()
+			w.Header().Set("Idempotent-Replayed", "true")
+			if err := openPayment(w, r, stored.body); err != nil {
+				respondError(w, err)
+			}
+			return
+		}
+	}
+
+	// Lock the reservations so a concurrent confirm or the expiry cronjob
+	// cannot change them under us, and price them
+	now := time.Now()
+	o, err := createOrder(tx, reservationIDs, now)
+	if err != nil {
 		tx.Rollback()
-		http.Error(w, "Invalid or expired reservation", http.StatusBadRequest)
+		respondError(w, err)
 		return
 	}
 
-	// Update reservation status
-	_, err = tx.Exec(`UPDATE reservations SET status = 'CONFIRMED' WHERE id = $1`, reservationID)
+	if idempotencyKey != "" {
+		err = saveIdempotentResponse(tx, "/confirm", idempotencyKey, http.StatusCreated, o.ID)
+		if err != nil {
+			tx.Rollback()
+			internalError(w, err)
+			return
+		}
+	}
+
+	// Commit before calling the provider, so a slow provider does not keep
+	// the reservations locked
+	err = tx.Commit()
 	if err != nil {
-		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 
-	// Update ticket status
-	_, err = tx.Exec(`UPDATE tickets SET status = 'BOOKED' WHERE id = (SELECT ticket_id FROM reservations WHERE id = $1)`, reservationID)
+	err = openPayment(w, r, o.ID)
+	if err == nil {
+		return
+	}
+	// Nobody can pay the order without its client secret, so give the
+	// reservations back and let the client try again
+	if cleanupErr := abandonCheckout(o.ID, idempotencyKey); cleanupErr != nil {
+		log.Printf("Error abandoning order %s: %v", o.ID, cleanupErr)
+	}
+	respondError(w, err)
+}
+
+// checkoutResponse is the body of POST /confirm: the order and what the
+// client needs to pay it.
+type checkoutResponse struct {
+	*order
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// openPayment opens the payment intent of a committed order, attaches it in
+// a short transaction of its own and responds with the checkout. The order
+// ID is the idempotency key at the provider, so running it again for the
+// same order returns the same intent.
+func openPayment(w http.ResponseWriter, r *http.Request, orderID string) error {
+	o, err := loadOrder(db, orderID, false)
 	if err != nil {
-		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	checkout := checkoutResponse{order: o}
+	if o.Status == orderPending {
+		intent, err := payments.CreateIntent(r.Context(), o.TotalCents, o.Currency, o.ID, map[string]string{"order_id": o.ID})
+		if err != nil {
+			return providerError(fmt.Errorf("create payment intent for order %s: %w", o.ID, err))
+		}
+		attached, err := setPaymentIntent(o, intent.ID)
+		if err != nil {
+			return err
+		}
+		if attached {
+			checkout.ClientSecret = intent.ClientSecret
+		} else {
+			// The order was given up meanwhile; make sure nobody pays it
+			if err := voidPayment(r.Context(), intent.ID); err != nil {
+				log.Printf("Error voiding payment intent %s: %v", intent.ID, err)
+			}
+			if checkout.order, err = loadOrder(db, orderID, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	response, err := json.Marshal(checkout)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+	return nil
+}
+
+// abandonCheckout deletes an order whose payment intent could not be
+// opened, together with its Idempotency-Key, so the reservations can be
+// checked out again.
+func abandonCheckout(orderID, idempotencyKey string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	o, err := loadOrder(tx, orderID, true)
+	if err != nil {
+		return err
+	}
+	if o.PaymentIntentID != "" {
+		// A retry opened the intent after all
+		return nil
+	}
+	if _, err := tx.Exec(`DELETE FROM order_items WHERE order_id = $1`, o.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM orders WHERE id = $1`, o.ID); err != nil {
+		return err
+	}
+	if idempotencyKey != "" {
+		if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE endpoint = '/confirm' AND key = $1`, idempotencyKey); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// userReservation is one row of the GET /reservations response
+type userReservation struct {
+	ReservationID string    `json:"reservation_id"`
+	TicketID      string    `json:"ticket_id"`
+	EventID       string    `json:"event_id"`
+	SeatNumber    string    `json:"seat_number"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	ExpiresIn     int       `json:"expires_in_seconds,omitempty"` // pending holds only
+}
+
+// listReservations -> GET /reservations?user_id=...&event_id=...
+// Returns the user's pending holds that have not expired yet and their
+// confirmed bookings, newest first. event_id is optional.
+func listReservations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed")
 		return
 	}
+	userID := r.URL.Query().Get("user_id")
+	if _, err := uuid.Parse(userID); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing user_id")
+		return
+	}
+	// Postgres prints UUIDs in lower case; compare with the parsed form so
+	// any spelling of the ID matches
+	eventID := r.URL.Query().Get("event_id")
+	if eventID != "" {
+		parsed, err := uuid.Parse(eventID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid event_id")
+			return
+		}
+		eventID = parsed.String()
+	}
 
-	err = tx.Commit()
+	now := time.Now()
+	rows, err := db.Query(`SELECT r.id, r.ticket_id, t.event_id, t.seat_number, r.status, r.created_at, r.expires_at
+		FROM reservations r JOIN tickets t ON t.id = r.ticket_id
+		WHERE r.user_id = $1 AND ($2 = '' OR t.event_id::text = $2)
+		AND (r.status = 'CONFIRMED' OR (r.status = 'PENDING' AND r.expires_at > $3))
+		ORDER BY r.created_at DESC`, userID, eventID, now)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
+	defer rows.Close()
 
-	fmt.Fprintf(w, "Reservation confirmed")
+	holds := []userReservation{}
+	bookings := []userReservation{}
+	for rows.Next() {
+		var res userReservation
+		err := rows.Scan(&res.ReservationID, &res.TicketID, &res.EventID, &res.SeatNumber, &res.Status, &res.CreatedAt, &res.ExpiresAt)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if res.Status == "PENDING" {
+			res.ExpiresIn = int(math.Ceil(res.ExpiresAt.Sub(now).Seconds()))
+			holds = append(holds, res)
+		} else {
+			bookings = append(bookings, res)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"holds":    holds,
+		"bookings": bookings,
+	})
 }
 
 func main() {
+	flag.StringVar(&pricing.Currency, "currency", "USD", "currency of ticket prices and orders")
+	flag.Int64Var(&pricing.ServiceFeeCents, "service-fee-cents", 150, "flat service fee per ticket, in cents")
+	flag.Int64Var(&pricing.ServiceFeeBasisPoints, "service-fee-bps", 1000, "service fee per ticket, in basis points of its price")
+	flag.Int64Var(&pricing.OrderFeeCents, "order-fee-cents", 250, "processing fee per order, in cents")
+	flag.Int64Var(&pricing.TaxBasisPoints, "tax-bps", 800, "tax on subtotal plus fees, in basis points")
+	provider := flag.String("payment-provider", "fake", "payment provider; only \"fake\" (in-memory, for local runs) exists yet")
+	fakeWebhookURL := flag.String("fakepay-webhook-url", "http://localhost:8080/webhooks/payments", "where the fake provider delivers webhooks")
+	fakeDropWebhooks := flag.Bool("fakepay-drop-webhooks", false, "make the fake provider lose every webhook, to exercise reconciliation")
+	reconcileInterval := flag.Duration("reconcile-interval", time.Minute, "how often to settle pending orders whose webhook may have been lost")
+	flag.Parse()
+	if pricing.ServiceFeeCents < 0 || pricing.ServiceFeeBasisPoints < 0 || pricing.OrderFeeCents < 0 || pricing.TaxBasisPoints < 0 {
+		log.Fatal("fees and tax must not be negative")
+	}
+
+	webhookSecret = []byte(os.Getenv("PAYMENT_WEBHOOK_SECRET"))
+	if len(webhookSecret) == 0 {
+		if *provider != "fake" {
+			log.Fatal("PAYMENT_WEBHOOK_SECRET is required")
+		}
+		// The fake provider runs in this process, so a throwaway secret will do
+		webhookSecret = make([]byte, 32)
+		rand.Read(webhookSecret)
+	}
+
+	ticketSecret = []byte(os.Getenv("TICKET_SIGNING_SECRET"))
+	if len(ticketSecret) == 0 {
+		log.Fatal("TICKET_SIGNING_SECRET is required to sign ticket QR codes")
+	}
+
+	// Without it the admin routes refuse every request
+	adminToken = []byte(os.Getenv("ADMIN_TOKEN"))
+
+	var err error
+	scannerTokens, err = parseScannerTokens(os.Getenv("SCANNER_TOKENS"))
+	if err != nil {
+		log.Fatal("SCANNER_TOKENS: ", err)
+	}
+	if len(scannerTokens) == 0 {
+		log.Println("SCANNER_TOKENS is not set; every check-in will be refused")
+	}
+
+	switch *provider {
+	case "fake":
+		fake := newFakeProvider(*fakeWebhookURL, webhookSecret, *fakeDropWebhooks)
+		payments = fake
+		http.HandleFunc("POST /fakepay/intents/{id}/confirm", fake.confirmIntent)
+	default:
+		log.Fatalf("unknown payment provider %q", *provider)
+	}
+
 	initDB()
 
 	http.HandleFunc("/reserve", reserveTicket)
 	http.HandleFunc("/confirm", confirmReservation)
+	http.HandleFunc("/reservations", listReservations)
+	http.HandleFunc("GET /orders/{id}", getOrder)
+	http.HandleFunc("POST /orders/{id}/cancel", cancelOrder)
+	http.HandleFunc("POST /admin/orders/{id}/refund", refundOrder)
+	http.HandleFunc("GET /orders/{id}/tickets", listOrderTickets)
+	http.HandleFunc("GET /tickets/{code}/qr.png", ticketQR)
+	http.HandleFunc("POST /checkin", checkIn)
+	http.HandleFunc("POST /webhooks/payments", paymentWebhook)
+
+	go reconcilePayments(*reconcileInterval)
 
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
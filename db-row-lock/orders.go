@@ -0,0 +1,444 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// An order groups the reservations a user checks out together and fixes
+// what they pay for them:
+//
+//	PENDING --> PAID --> REFUNDED
+//	   |
+//	   +-----> CANCELLED
+//
+// An order is PENDING while its payment intent is open (see payments.go),
+// becomes PAID once the provider reports the intent succeeded, and is
+// CANCELLED if the payment fails, a hold runs out first or the user gives
+// up. See reconcileOrder for every case.
+//
+// Ticket prices and fees are copied into the order when it is created, so a
+// later price or fee change does not touch orders already placed. Amounts
+// are integer cents in the order currency.
+
+const (
+	orderPending   = "PENDING"
+	orderPaid      = "PAID"
+	orderCancelled = "CANCELLED"
+	orderRefunded  = "REFUNDED"
+)
+
+// pricingRules decide the fees and tax of an order. Set from flags in main.
+type pricingRules struct {
+	Currency              string
+	ServiceFeeCents       int64 // per ticket
+	ServiceFeeBasisPoints int64 // per ticket, of the ticket price
+	OrderFeeCents         int64 // once per order
+	TaxBasisPoints        int64 // of subtotal plus all fees
+}
+
+var pricing pricingRules
+
+// basisPoints returns bps/10000 of amount, rounded half up.
+func basisPoints(amount, bps int64) int64 {
+	return (amount*bps + 5000) / 10000
+}
+
+// apply fills in the fees and totals of o from its items.
+func (p pricingRules) apply(o *order) {
+	o.Currency = p.Currency
+	o.SubtotalCents, o.ServiceFeeCents = 0, 0
+	for i := range o.Items {
+		item := &o.Items[i]
+		item.ServiceFeeCents = p.ServiceFeeCents + basisPoints(item.PriceCents, p.ServiceFeeBasisPoints)
+		o.SubtotalCents += item.PriceCents
+		o.ServiceFeeCents += item.ServiceFeeCents
+	}
+	o.OrderFeeCents = p.OrderFeeCents
+	o.TaxCents = basisPoints(o.SubtotalCents+o.ServiceFeeCents+o.OrderFeeCents, p.TaxBasisPoints)
+	o.TotalCents = o.SubtotalCents + o.ServiceFeeCents + o.OrderFeeCents + o.TaxCents
+}
+
+// orderItem is one reserved ticket of an order.
+type orderItem struct {
+	ReservationID   string `json:"reservation_id"`
+	TicketID        string `json:"ticket_id"`
+	SeatNumber      string `json:"seat_number"`
+	PriceCents      int64  `json:"price_cents"`
+	ServiceFeeCents int64  `json:"service_fee_cents"`
+}
+
+type order struct {
+	ID              string      `json:"id"`
+	UserID          string      `json:"user_id"`
+	Status          string      `json:"status"`
+	PaymentIntentID string      `json:"payment_intent_id,omitempty"`
+	Currency        string      `json:"currency"`
+	Items           []orderItem `json:"items"`
+	SubtotalCents   int64       `json:"subtotal_cents"`
+	ServiceFeeCents int64       `json:"service_fee_cents"` // sum over the items
+	OrderFeeCents   int64       `json:"order_fee_cents"`
+	TaxCents        int64       `json:"tax_cents"`
+	TotalCents      int64       `json:"total_cents"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// parseReservationIDs reads either "reservation_id" or "reservation_ids"
+// from a request body. It returns an error message if neither is valid.
+func parseReservationIDs(req map[string]interface{}) ([]string, string) {
+	var raw []interface{}
+	if list, ok := req["reservation_ids"].([]interface{}); ok {
+		raw = list
+	} else if id, ok := req["reservation_id"]; ok {
+		raw = []interface{}{id}
+	}
+	if len(raw) == 0 {
+		return nil, "Missing reservation_id"
+	}
+
+	ids := make([]string, 0, len(raw))
+	seen := make(map[string]bool)
+	for _, v := range raw {
+		id, _ := v.(string)
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return nil, "Invalid reservation_id"
+		}
+		if seen[parsed.String()] {
+			return nil, "Duplicate reservation_id " + id
+		}
+		seen[parsed.String()] = true
+		ids = append(ids, parsed.String())
+	}
+	return ids, ""
+}
+
+// createOrder locks the given reservations and stores a PENDING order for
+// them. All of them must be pending holds of the same user.
+func createOrder(tx *sql.Tx, reservationIDs []string, now time.Time) (*order, error) {
+	rows, err := tx.Query(`SELECT r.id, r.ticket_id, r.user_id, r.status, r.expires_at, t.seat_number, t.price_cents,
+			EXISTS (SELECT 1 FROM order_items oi WHERE oi.reservation_id = r.id)
+		FROM reservations r JOIN tickets t ON t.id = r.ticket_id
+		WHERE r.id = ANY($1::uuid[])
+		ORDER BY r.id
+		FOR UPDATE OF r`, pq.Array(reservationIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	o := &order{ID: uuid.NewString(), Status: orderPending, CreatedAt: now, UpdatedAt: now}
+	for rows.Next() {
+		var item orderItem
+		var userID, status string
+		var expiresAt time.Time
+		var inOrder bool
+		err := rows.Scan(&item.ReservationID, &item.TicketID, &userID, &status, &expiresAt, &item.SeatNumber, &item.PriceCents, &inOrder)
+		if err != nil {
+			return nil, err
+		}
+		if status == "CANCELLED" || (status == "PENDING" && !expiresAt.After(now)) {
+			return nil, &requestError{http.StatusGone, "RESERVATION_EXPIRED", "Reservation expired"}
+		}
+		if status != "PENDING" {
+			return nil, &requestError{http.StatusConflict, "RESERVATION_NOT_PENDING", "Reservation is already " + strings.ToLower(status)}
+		}
+		if inOrder {
+			return nil, &requestError{http.StatusConflict, "RESERVATION_IN_CHECKOUT", "Reservation is already being checked out"}
+		}
+		if o.UserID == "" {
+			o.UserID = userID
+		} else if o.UserID != userID {
+			return nil, &requestError{http.StatusBadRequest, "RESERVATIONS_SPAN_USERS", "Reservations of one order must belong to the same user"}
+		}
+		o.Items = append(o.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(o.Items) != len(reservationIDs) {
+		return nil, &requestError{http.StatusNotFound, "RESERVATION_NOT_FOUND", "Reservation not found"}
+	}
+
+	pricing.apply(o)
+
+	_, err = tx.Exec(`INSERT INTO orders (id, user_id, status, currency, subtotal_cents, service_fee_cents, order_fee_cents, tax_cents, total_cents, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)`,
+		o.ID, o.UserID, o.Status, o.Currency, o.SubtotalCents, o.ServiceFeeCents, o.OrderFeeCents, o.TaxCents, o.TotalCents, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range o.Items {
+		_, err = tx.Exec(`INSERT INTO order_items (order_id, reservation_id, ticket_id, seat_number, price_cents, service_fee_cents)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			o.ID, item.ReservationID, item.TicketID, item.SeatNumber, item.PriceCents, item.ServiceFeeCents)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// payOrder books the tickets of a PENDING order, issues them (see
+// tickets.go) and marks the order PAID.
+func payOrder(tx *sql.Tx, o *order, now time.Time) error {
+	_, err := tx.Exec(`UPDATE reservations SET status = 'CONFIRMED' WHERE id IN (SELECT reservation_id FROM order_items WHERE order_id = $1)`, o.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE tickets SET status = 'BOOKED' WHERE id IN (SELECT ticket_id FROM order_items WHERE order_id = $1)`, o.ID)
+	if err != nil {
+		return err
+	}
+	if err := issueTickets(tx, o, now); err != nil {
+		return err
+	}
+	return setOrderStatus(tx, o, orderPaid, now)
+}
+
+// releaseOrder cancels the reservations of an order, puts its tickets back
+// on sale, voids any tickets issued for it and moves it to status
+// (CANCELLED or REFUNDED). Tickets whose
+// reservation the expiry cronjob already cancelled are left alone; someone
+// else may hold them by now.
+func releaseOrder(tx *sql.Tx, o *order, status string, now time.Time) error {
+	_, err := tx.Exec(`WITH released AS (
+			UPDATE reservations SET status = 'CANCELLED'
+			WHERE id IN (SELECT reservation_id FROM order_items WHERE order_id = $1) AND status <> 'CANCELLED'
+			RETURNING ticket_id
+		)
+		UPDATE tickets SET status = 'AVAILABLE' WHERE id IN (SELECT ticket_id FROM released)`, o.ID)
+	if err != nil {
+		return err
+	}
+	if err := voidTickets(tx, o, now); err != nil {
+		return err
+	}
+	return setOrderStatus(tx, o, status, now)
+}
+
+// holdsActive locks the reservations of o and reports whether every one of
+// them is still pending and unexpired.
+func holdsActive(tx *sql.Tx, o *order, now time.Time) (bool, error) {
+	rows, err := tx.Query(`SELECT status, expires_at FROM reservations
+		WHERE id IN (SELECT reservation_id FROM order_items WHERE order_id = $1)
+		ORDER BY id
+		FOR UPDATE`, o.ID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	active := true
+	for rows.Next() {
+		var status string
+		var expiresAt time.Time
+		if err := rows.Scan(&status, &expiresAt); err != nil {
+			return false, err
+		}
+		if status != "PENDING" || !expiresAt.After(now) {
+			active = false
+		}
+	}
+	return active, rows.Err()
+}
+
+// setPaymentIntent attaches an intent to o unless the order is no longer
+// PENDING or already has another one, and reports whether it did.
+func setPaymentIntent(o *order, intentID string) (bool, error) {
+	res, err := db.Exec(`UPDATE orders SET payment_intent_id = $2
+		WHERE id = $1 AND status = 'PENDING' AND (payment_intent_id IS NULL OR payment_intent_id = $2)`, o.ID, intentID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	o.PaymentIntentID = intentID
+	return true, nil
+}
+
+func setOrderStatus(tx *sql.Tx, o *order, status string, now time.Time) error {
+	_, err := tx.Exec(`UPDATE orders SET status = $2, updated_at = $3 WHERE id = $1`, o.ID, status, now)
+	if err != nil {
+		return err
+	}
+	o.Status = status
+	o.UpdatedAt = now
+	return nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// loadOrder reads an order and its items. With forUpdate the order row is
+// locked until tx ends.
+func loadOrder(q queryer, id string, forUpdate bool) (*order, error) {
+	query := `SELECT id, user_id, status, COALESCE(payment_intent_id, ''), currency, subtotal_cents, service_fee_cents, order_fee_cents, tax_cents, total_cents, created_at, updated_at
+		FROM orders WHERE id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var o order
+	err := q.QueryRow(query, id).Scan(&o.ID, &o.UserID, &o.Status, &o.PaymentIntentID, &o.Currency, &o.SubtotalCents, &o.ServiceFeeCents,
+		&o.OrderFeeCents, &o.TaxCents, &o.TotalCents, &o.CreatedAt, &o.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, &requestError{http.StatusNotFound, "ORDER_NOT_FOUND", "Order not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`SELECT reservation_id, ticket_id, seat_number, price_cents, service_fee_cents
+		FROM order_items WHERE order_id = $1 ORDER BY reservation_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	o.Items = []orderItem{}
+	for rows.Next() {
+		var item orderItem
+		if err := rows.Scan(&item.ReservationID, &item.TicketID, &item.SeatNumber, &item.PriceCents, &item.ServiceFeeCents); err != nil {
+			return nil, err
+		}
+		o.Items = append(o.Items, item)
+	}
+	return &o, rows.Err()
+}
+
+// loadOrderByIntent reads and locks the order paid by a payment intent.
+func loadOrderByIntent(tx *sql.Tx, intentID string) (*order, error) {
+	var id string
+	err := tx.QueryRow(`SELECT id FROM orders WHERE payment_intent_id = $1`, intentID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, &requestError{http.StatusNotFound, "ORDER_NOT_FOUND", "Order not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadOrder(tx, id, true)
+}
+
+func writeOrder(w http.ResponseWriter, status int, o *order) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(o)
+}
+
+// getOrder -> GET /orders/{id}?user_id=...
+// Only the user who placed the order gets it back.
+func getOrder(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid order ID")
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	if _, err := uuid.Parse(userID); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing user_id")
+		return
+	}
+	o, err := loadOrder(db, id, false)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if err := checkOrderOwner(o, userID); err != nil {
+		respondError(w, err)
+		return
+	}
+	writeOrder(w, http.StatusOK, o)
+}
+
+// cancelOrder -> POST /orders/{id}/cancel {"user_id": "..."}
+// Abandons a PENDING order of the user: its payment intent is voided and
+// its seats released.
+func cancelOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+		return
+	}
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing user_id")
+		return
+	}
+	changeOrder(w, r, req.UserID, orderPending, "ORDER_NOT_PENDING", func(tx *sql.Tx, o *order, now time.Time) error {
+		if o.PaymentIntentID != "" {
+			if err := voidPayment(r.Context(), o.PaymentIntentID); err != nil {
+				return providerError(err)
+			}
+		}
+		return releaseOrder(tx, o, orderCancelled, now)
+	})
+}
+
+// refundOrder -> POST /admin/orders/{id}/refund
+// Refunds a PAID order and puts its seats back on sale. Admins only.
+func refundOrder(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	changeOrder(w, r, "", orderPaid, "ORDER_NOT_PAID", func(tx *sql.Tx, o *order, now time.Time) error {
+		if err := payments.RefundIntent(r.Context(), o.PaymentIntentID); err != nil {
+			return providerError(err)
+		}
+		return releaseOrder(tx, o, orderRefunded, now)
+	})
+}
+
+// changeOrder locks the order named in the path, checks it belongs to
+// userID (unless that is empty) and is in status from (failing with code
+// otherwise), applies change and responds with the updated order. A
+// *requestError from change is sent as is.
+func changeOrder(w http.ResponseWriter, r *http.Request, userID, from, code string, change func(*sql.Tx, *order, time.Time) error) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid order ID")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	o, err := loadOrder(tx, id, true)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if userID != "" {
+		if err := checkOrderOwner(o, userID); err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+	if o.Status != from {
+		writeError(w, http.StatusConflict, code, "Order is already "+strings.ToLower(o.Status))
+		return
+	}
+	if err := change(tx, o, time.Now()); err != nil {
+		respondError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(w, err)
+		return
+	}
+	writeOrder(w, http.StatusOK, o)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Checkout takes two steps. POST /confirm prices the reservations as a
+// PENDING order and opens a payment intent for its total with the provider.
+// The client pays the intent with the provider, and the provider reports
+// the outcome to POST /webhooks/payments (see webhooks.go). Tickets are
+// booked only for an intent the provider reports as succeeded; the client
+// is never trusted to say so. The client follows along with
+// GET /orders/{id}.
+
+// PaymentIntentStatus is where an intent is in its life:
+//
+//	requires_payment --> succeeded --> refunded
+//	        |
+//	        +--> failed / canceled
+type PaymentIntentStatus string
+
+const (
+	IntentRequiresPayment PaymentIntentStatus = "requires_payment"
+	IntentSucceeded       PaymentIntentStatus = "succeeded"
+	IntentFailed          PaymentIntentStatus = "failed"
+	IntentCanceled        PaymentIntentStatus = "canceled"
+	IntentRefunded        PaymentIntentStatus = "refunded"
+)
+
+// PaymentIntent is one attempt to collect an amount from a customer.
+type PaymentIntent struct {
+	ID            string              `json:"id"`
+	AmountCents   int64               `json:"amount_cents"`
+	Currency      string              `json:"currency"`
+	Status        PaymentIntentStatus `json:"status"`
+	ClientSecret  string              `json:"client_secret,omitempty"` // lets the client pay this intent only
+	FailureReason string              `json:"failure_reason,omitempty"`
+	Metadata      map[string]string   `json:"metadata,omitempty"`
+}
+
+// PaymentProvider collects payments. Implementations must be safe for
+// concurrent use.
+type PaymentProvider interface {
+	// CreateIntent opens an intent for amountCents. Calls with the same
+	// idempotencyKey return the same intent.
+	CreateIntent(ctx context.Context, amountCents int64, currency, idempotencyKey string, metadata map[string]string) (*PaymentIntent, error)
+	GetIntent(ctx context.Context, id string) (*PaymentIntent, error)
+	// CancelIntent stops an unpaid intent from being paid. It does nothing
+	// to a failed or canceled intent and returns errIntentSucceeded for a
+	// paid one.
+	CancelIntent(ctx context.Context, id string) error
+	// RefundIntent gives back the amount of a succeeded intent. Refunding
+	// twice is not an error.
+	RefundIntent(ctx context.Context, id string) error
+}
+
+var (
+	errIntentNotFound  = errors.New("payment intent not found")
+	errIntentSucceeded = errors.New("payment intent already succeeded")
+	errIntentNotPaid   = errors.New("payment intent has not succeeded")
+)
+
+// payments is the provider in use, chosen by the -payment-provider flag.
+var payments PaymentProvider
+
+// providerError turns the error of a provider call made for a request into
+// one respondError can send: a 409 for an intent that was never paid, a 502
+// for anything else.
+func providerError(err error) error {
+	if errors.Is(err, errIntentNotPaid) {
+		return &requestError{http.StatusConflict, "PAYMENT_INTENT_NOT_PAID", "Payment of the order has not succeeded"}
+	}
+	log.Printf("payment provider error: %v", err)
+	return &requestError{http.StatusBadGateway, "PAYMENT_PROVIDER_UNAVAILABLE", "Payment provider is unavailable, try again"}
+}
+
+// voidPayment makes sure an intent ends up not charging the customer:
+// unpaid intents are canceled, paid ones refunded.
+func voidPayment(ctx context.Context, id string) error {
+	err := payments.CancelIntent(ctx, id)
+	if err == errIntentSucceeded {
+		return payments.RefundIntent(ctx, id)
+	}
+	return err
+}
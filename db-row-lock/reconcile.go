@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// reconcileOrder brings a locked order in line with the state of its
+// payment intent at the provider. Webhooks and the reconciliation job both
+// end up here, so it must be safe to run any number of times:
+//
+//	order      intent            result
+//	PENDING    succeeded         PAID, or refunded and CANCELLED if a hold ran out
+//	PENDING    failed/canceled   CANCELLED
+//	PENDING    refunded          CANCELLED
+//	PENDING    requires_payment  intent voided and CANCELLED once a hold ran out
+//	PAID       refunded          REFUNDED
+//	CANCELLED  succeeded         refunded (paid after the order was given up)
+//	PENDING    none              CANCELLED once a hold ran out
+//
+// An order has no intent when the service stopped between committing it
+// and attaching the intent. Its client secret never left the service, so
+// nobody can have paid it.
+func reconcileOrder(ctx context.Context, tx *sql.Tx, o *order, now time.Time) error {
+	if o.PaymentIntentID == "" {
+		active, err := holdsActive(tx, o, now)
+		if err != nil || active || o.Status != orderPending {
+			return err
+		}
+		return releaseOrder(tx, o, orderCancelled, now)
+	}
+
+	intent, err := payments.GetIntent(ctx, o.PaymentIntentID)
+	if err != nil {
+		return fmt.Errorf("get payment intent %s: %w", o.PaymentIntentID, err)
+	}
+
+	switch o.Status {
+	case orderPending:
+		switch intent.Status {
+		case IntentSucceeded:
+			active, err := holdsActive(tx, o, now)
+			if err != nil {
+				return err
+			}
+			if active {
+				return payOrder(tx, o, now)
+			}
+			// The seats may be someone else's by now
+			if err := payments.RefundIntent(ctx, intent.ID); err != nil {
+				return fmt.Errorf("refund payment intent %s: %w", intent.ID, err)
+			}
+			return releaseOrder(tx, o, orderCancelled, now)
+
+		case IntentFailed, IntentCanceled, IntentRefunded:
+			return releaseOrder(tx, o, orderCancelled, now)
+
+		case IntentRequiresPayment:
+			active, err := holdsActive(tx, o, now)
+			if err != nil || active {
+				return err
+			}
+			if err := voidPayment(ctx, intent.ID); err != nil {
+				return fmt.Errorf("void payment intent %s: %w", intent.ID, err)
+			}
+			return releaseOrder(tx, o, orderCancelled, now)
+		}
+
+	case orderPaid:
+		if intent.Status == IntentRefunded {
+			return releaseOrder(tx, o, orderRefunded, now)
+		}
+
+	case orderCancelled:
+		if intent.Status == IntentSucceeded {
+			if err := payments.RefundIntent(ctx, intent.ID); err != nil {
+				return fmt.Errorf("refund payment intent %s: %w", intent.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reconcilePayments runs forever. A webhook can be lost, so every interval
+// it asks the provider about each PENDING order with a hold that runs out
+// before the next run, and settles the order as the webhook would have.
+func reconcilePayments(interval time.Duration) {
+	for range time.Tick(interval) {
+		ids, err := ordersDueForReconcile(time.Now().Add(interval))
+		if err != nil {
+			log.Println("Error finding orders to reconcile:", err)
+			continue
+		}
+		for _, id := range ids {
+			if err := reconcileOrderID(id); err != nil {
+				log.Printf("Error reconciling order %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// ordersDueForReconcile returns the PENDING orders with a hold that is no
+// longer pending or expires before deadline.
+func ordersDueForReconcile(deadline time.Time) ([]string, error) {
+	rows, err := db.Query(`SELECT o.id FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		JOIN reservations r ON r.id = oi.reservation_id
+		WHERE o.status = 'PENDING'
+		GROUP BY o.id
+		HAVING bool_or(r.status <> 'PENDING' OR r.expires_at < $1)`, deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func reconcileOrderID(id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	o, err := loadOrder(tx, id, true)
+	if err != nil {
+		return err
+	}
+	if o.Status != orderPending {
+		// A webhook got here first
+		return nil
+	}
+	if err := reconcileOrder(context.Background(), tx, o, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
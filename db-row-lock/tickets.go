@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Paying an order issues one ticket per booked seat: the thing a guest shows
+// at the door. Each has a random code and a QR payload signed with
+// TICKET_SIGNING_SECRET:
+//
+//	T1.<code>.<event id>.<base64url HMAC-SHA256 of "T1.<code>.<event id>", 16 bytes>
+//
+// so a scanner can tell a forged or altered QR code from a real one before
+// looking anything up. A ticket is checked in once; a second scan of the
+// same code is refused. Refunding the order voids its tickets.
+
+const ticketQRVersion = "T1"
+
+// ticketSecret signs QR payloads; set from TICKET_SIGNING_SECRET in main.
+// Changing it invalidates every ticket already issued.
+var ticketSecret []byte
+
+// issuedTicket is a ticket for one booked seat.
+type issuedTicket struct {
+	Code          string     `json:"code"`
+	QR            string     `json:"qr"` // signed payload in the QR code
+	OrderID       string     `json:"order_id"`
+	ReservationID string     `json:"reservation_id"`
+	TicketID      string     `json:"ticket_id"`
+	EventID       string     `json:"event_id"`
+	SeatNumber    string     `json:"seat_number"`
+	UserID        string     `json:"user_id"`
+	IssuedAt      time.Time  `json:"issued_at"`
+	CheckedInAt   *time.Time `json:"checked_in_at,omitempty"`
+	CheckedInBy   string     `json:"checked_in_by,omitempty"` // scanner that let the guest in
+	VoidedAt      *time.Time `json:"voided_at,omitempty"`
+}
+
+var errBadTicketQR = errors.New("invalid ticket QR code")
+
+// ticketCodeEncoding spells codes in upper case letters and digits 2-7,
+// which are hard to misread when typed in by hand.
+var ticketCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTicketCode returns a random 16 character code.
+func newTicketCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return ticketCodeEncoding.EncodeToString(b), nil
+}
+
+func ticketMAC(code, eventID string) []byte {
+	mac := hmac.New(sha256.New, ticketSecret)
+	mac.Write([]byte(ticketQRVersion + "." + code + "." + eventID))
+	return mac.Sum(nil)[:16]
+}
+
+// signTicket returns the QR payload of a ticket.
+func signTicket(code, eventID string) string {
+	return ticketQRVersion + "." + code + "." + eventID + "." + base64.RawURLEncoding.EncodeToString(ticketMAC(code, eventID))
+}
+
+// verifyTicketQR checks the signature of a QR payload and returns the
+// ticket code and event ID it carries.
+func verifyTicketQR(payload string) (code, eventID string, err error) {
+	parts := strings.Split(strings.TrimSpace(payload), ".")
+	if len(parts) != 4 || parts[0] != ticketQRVersion {
+		return "", "", errBadTicketQR
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !hmac.Equal(signature, ticketMAC(parts[1], parts[2])) {
+		return "", "", errBadTicketQR
+	}
+	return parts[1], parts[2], nil
+}
+
+// issueTickets creates a ticket for every seat of an order being paid.
+func issueTickets(tx *sql.Tx, o *order, now time.Time) error {
+	for _, item := range o.Items {
+		code, err := newTicketCode()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO issued_tickets (code, order_id, reservation_id, ticket_id, event_id, seat_number, user_id, issued_at)
+			SELECT $1, $2, $3, t.id, t.event_id, t.seat_number, $5, $6 FROM tickets t WHERE t.id = $4`,
+			code, o.ID, item.ReservationID, item.TicketID, o.UserID, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// voidTickets makes the tickets of a refunded order unusable.
+func voidTickets(tx *sql.Tx, o *order, now time.Time) error {
+	_, err := tx.Exec(`UPDATE issued_tickets SET voided_at = $2 WHERE order_id = $1 AND voided_at IS NULL`, o.ID, now)
+	return err
+}
+
+const issuedTicketColumns = `code, order_id, reservation_id, ticket_id, event_id, seat_number, user_id, issued_at, checked_in_at, COALESCE(checked_in_by, ''), voided_at`
+
+func scanIssuedTicket(row interface{ Scan(...interface{}) error }) (*issuedTicket, error) {
+	var t issuedTicket
+	err := row.Scan(&t.Code, &t.OrderID, &t.ReservationID, &t.TicketID, &t.EventID, &t.SeatNumber, &t.UserID,
+		&t.IssuedAt, &t.CheckedInAt, &t.CheckedInBy, &t.VoidedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.QR = signTicket(t.Code, t.EventID)
+	return &t, nil
+}
+
+// listOrderTickets -> GET /orders/{id}/tickets?user_id=...
+// Returns the tickets of the user's paid order, with the QR payload of
+// each.
+func listOrderTickets(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid order ID")
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	if _, err := uuid.Parse(userID); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing user_id")
+		return
+	}
+
+	o, err := loadOrder(db, id, false)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	// The QR payloads get anyone in. user_id is not authenticated yet, so
+	// this only keeps out callers who do not know whose order it is
+	if err := checkOrderOwner(o, userID); err != nil {
+		respondError(w, err)
+		return
+	}
+	rows, err := db.Query(`SELECT `+issuedTicketColumns+` FROM issued_tickets WHERE order_id = $1 ORDER BY seat_number`, o.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	tickets := []*issuedTicket{}
+	for rows.Next() {
+		t, err := scanIssuedTicket(rows)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		tickets = append(tickets, t)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tickets)
+}
+
+// ticketQR -> GET /tickets/{code}/qr.png?size=256
+// Renders the signed QR payload of a ticket as a PNG image.
+func ticketQR(w http.ResponseWriter, r *http.Request) {
+	size := 256
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 128 || n > 1024 {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "size must be between 128 and 1024")
+			return
+		}
+		size = n
+	}
+
+	var eventID string
+	err := db.QueryRow(`SELECT event_id FROM issued_tickets WHERE code = $1`, r.PathValue("code")).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "TICKET_NOT_FOUND", "Ticket not found")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	png, err := qrcode.Encode(signTicket(r.PathValue("code"), eventID), qrcode.Medium, size)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	// Whoever has the image can get in; keep it out of shared caches
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(png)
+}
+
+// checkIn -> POST /checkin
+// Called by door scanners, authenticated with their token from
+// SCANNER_TOKENS, with the scanned QR payload:
+//
+//	{"qr": "T1....", "event_id": "..."}
+//
+// Lets the ticket in once. A forged payload, a ticket for another event, a
+// voided ticket or a second scan of the same ticket is refused.
+func checkIn(w http.ResponseWriter, r *http.Request) {
+	scanner, ok := requireScanner(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		QR      string `json:"qr"`
+		EventID string `json:"event_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+		return
+	}
+	if _, err := uuid.Parse(req.EventID); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing event_id")
+		return
+	}
+
+	code, eventID, err := verifyTicketQR(req.QR)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_TICKET", "Ticket QR code is not genuine")
+		return
+	}
+	if !strings.EqualFold(eventID, req.EventID) {
+		writeError(w, http.StatusConflict, "WRONG_EVENT", "Ticket is for another event")
+		return
+	}
+
+	// Only one scan can flip checked_in_at, however many race for it
+	row := db.QueryRow(`UPDATE issued_tickets SET checked_in_at = $2, checked_in_by = $3
+		WHERE code = $1 AND checked_in_at IS NULL AND voided_at IS NULL
+		RETURNING `+issuedTicketColumns, code, time.Now(), scanner)
+	ticket, err := scanIssuedTicket(row)
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ticket)
+		return
+	}
+	if err != sql.ErrNoRows {
+		internalError(w, err)
+		return
+	}
+
+	// Find out why it was refused
+	ticket, err = scanIssuedTicket(db.QueryRow(`SELECT `+issuedTicketColumns+` FROM issued_tickets WHERE code = $1`, code))
+	switch {
+	case err == sql.ErrNoRows:
+		writeError(w, http.StatusNotFound, "TICKET_NOT_FOUND", "Ticket not found")
+	case err != nil:
+		internalError(w, err)
+	case ticket.VoidedAt != nil:
+		writeError(w, http.StatusGone, "TICKET_VOIDED", "Ticket was voided")
+	default:
+		msg := "Ticket was already scanned at " + ticket.CheckedInAt.Format(time.RFC3339)
+		if ticket.CheckedInBy != "" {
+			msg += " by " + ticket.CheckedInBy
+		}
+		writeError(w, http.StatusConflict, "ALREADY_CHECKED_IN", msg)
+	}
+}
@@ -1,3 +1,5 @@
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS payments;
 DROP TABLE IF EXISTS tickets;
 DROP TABLE IF EXISTS events;
 
@@ -14,22 +16,48 @@ CREATE TABLE tickets (
     id UUID PRIMARY KEY,
     event_id UUID REFERENCES events(id),
     seat_number TEXT NOT NULL,
+    price_cents BIGINT NOT NULL DEFAULT 0 CHECK (price_cents >= 0),
     status TEXT NOT NULL CHECK (status IN ('AVAILABLE', 'RESERVED', 'BOOKED')),
     user_id UUID
 );
 
+-- Payment intents opened at checkout; a ticket is booked only once its
+-- intent succeeds
+CREATE TABLE payments (
+    intent_id TEXT PRIMARY KEY,
+    ticket_id UUID NOT NULL REFERENCES tickets(id),
+    user_id UUID NOT NULL,
+    amount_cents BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('PENDING', 'SUCCEEDED', 'FAILED', 'CANCELLED', 'REFUNDED')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- One open checkout per user and ticket
+CREATE UNIQUE INDEX payments_pending_idx ON payments (ticket_id, user_id) WHERE status = 'PENDING';
+
+-- Payment webhook events already handled; a redelivery is acknowledged
+-- and skipped
+CREATE TABLE webhook_events (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    intent_id TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
 -- Seed 1 event and 10 tickets
 INSERT INTO events (id, name, date, venue, total_seats, available_seats)
 VALUES ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'Concert', '2021-12-31 20:00:00', 'Venue', 10, 10);
 
-INSERT INTO tickets (id, event_id, seat_number, status)
-VALUES ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a12', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A1', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a13', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A2', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a14', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A3', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a15', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A4', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a16', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A5', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a17', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A6', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a18', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A7', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a19', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A8', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a20', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A9', 'AVAILABLE'),
-         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a21', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A10', 'AVAILABLE');
\ No newline at end of file
+INSERT INTO tickets (id, event_id, seat_number, price_cents, status)
+VALUES ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a12', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A1', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a13', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A2', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a14', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A3', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a15', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A4', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a16', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A5', 7500, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a17', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A6', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a18', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A7', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a19', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A8', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a20', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A9', 5000, 'AVAILABLE'),
+         ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a21', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'A10', 5000, 'AVAILABLE');
\ No newline at end of file
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 )
 
@@ -17,6 +21,9 @@ var (
 	db  *sql.DB
 	rdb *redis.Client
 	ctx = context.Background()
+
+	// currency of ticket prices and payments
+	currency string
 )
 
 func initDB() {
@@ -37,18 +44,18 @@ func reserveTicket(w http.ResponseWriter, r *http.Request) {
 	var req map[string]interface{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
 		return
 	}
 
 	ticketID, ok := req["ticket_id"].(string)
 	if !ok || ticketID == "" {
-		http.Error(w, "Invalid or missing ticket_id", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing ticket_id")
 		return
 	}
 	userID, ok := req["user_id"].(string)
 	if !ok || userID == "" {
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing user_id")
 		return
 	}
 
@@ -58,11 +65,11 @@ func reserveTicket(w http.ResponseWriter, r *http.Request) {
 	// Try to acquire the lock with TTL
 	success, err := rdb.SetNX(ctx, lockKey, userID, ttl).Result()
 	if err != nil {
-		http.Error(w, "Failed to acquire lock", http.StatusInternalServerError)
+		internalError(w, fmt.Errorf("acquire lock: %w", err))
 		return
 	}
 	if !success {
-		http.Error(w, "Ticket is already reserved", http.StatusConflict)
+		writeError(w, http.StatusConflict, "TICKET_ALREADY_RESERVED", "Ticket is already reserved")
 		return
 	}
 
@@ -73,22 +80,27 @@ func reserveTicket(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// confirmReservation -> POST /confirm
+// Starts checkout for a ticket the caller holds: opens a payment intent for
+// the ticket price and returns its ID and client secret. Calling it again
+// for the same hold returns the same intent. The ticket is booked when the
+// provider's webhook reports the payment succeeded.
 func confirmReservation(w http.ResponseWriter, r *http.Request) {
 	var req map[string]interface{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
 		return
 	}
 
 	ticketID, ok := req["ticket_id"].(string)
 	if !ok || ticketID == "" {
-		http.Error(w, "Invalid or missing ticket_id", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing ticket_id")
 		return
 	}
 	userID, ok := req["user_id"].(string)
 	if !ok || userID == "" {
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid or missing user_id")
 		return
 	}
 
@@ -96,54 +108,115 @@ func confirmReservation(w http.ResponseWriter, r *http.Request) {
 	lockKey := fmt.Sprintf("ticket_lock:%s", ticketID)
 	storedUserID, err := rdb.Get(ctx, lockKey).Result()
 	if err == redis.Nil {
-		http.Error(w, "Reservation expired or not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "RESERVATION_NOT_FOUND", "Reservation expired or not found")
 		return
 	} else if err != nil {
-		http.Error(w, "Failed to verify reservation", http.StatusInternalServerError)
+		internalError(w, fmt.Errorf("verify reservation: %w", err))
 		return
 	}
 
 	// Check if the user IDs match
 	if storedUserID != userID {
-		http.Error(w, "User ID does not match the reservation", http.StatusForbidden)
+		writeError(w, http.StatusForbidden, "RESERVATION_OWNER_MISMATCH", "User ID does not match the reservation")
 		return
 	}
 
-	// Start a transaction to confirm the reservation in the tickets table
-	tx, err := db.Begin()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var priceCents int64
+	var ticketStatus string
+	err = db.QueryRow(`SELECT price_cents, status FROM tickets WHERE id = $1`, ticketID).Scan(&priceCents, &ticketStatus)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "TICKET_NOT_FOUND", "Ticket not found")
 		return
 	}
-
-	_, err = tx.Exec(`UPDATE tickets SET status = 'BOOKED', user_id = $1 WHERE id = $2`, userID, ticketID)
 	if err != nil {
-		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if ticketStatus == "BOOKED" {
+		writeError(w, http.StatusConflict, "TICKET_ALREADY_BOOKED", "Ticket is already booked")
 		return
 	}
 
-	// Remove the lock from Redis since the reservation is confirmed
-	rdb.Del(ctx, lockKey)
+	// Reuse the intent if this user already started paying for the ticket
+	var intent *PaymentIntent
+	var intentID string
+	err = db.QueryRow(`SELECT intent_id FROM payments WHERE ticket_id = $1 AND user_id = $2 AND status = 'PENDING'`, ticketID, userID).Scan(&intentID)
+	if err == nil {
+		intent, err = payments.GetIntent(r.Context(), intentID)
+		if err != nil {
+			internalError(w, fmt.Errorf("get payment intent: %w", err))
+			return
+		}
+	} else if err == sql.ErrNoRows {
+		intent, err = payments.CreateIntent(r.Context(), priceCents, currency, uuid.NewString(), map[string]string{"ticket_id": ticketID, "user_id": userID})
+		if err != nil {
+			internalError(w, fmt.Errorf("create payment intent: %w", err))
+			return
+		}
+		res, err := db.Exec(`INSERT INTO payments (intent_id, ticket_id, user_id, amount_cents, currency, status)
+			VALUES ($1, $2, $3, $4, $5, 'PENDING')
+			ON CONFLICT DO NOTHING`, intent.ID, ticketID, userID, intent.AmountCents, intent.Currency)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// A concurrent call for the same hold won; its intent is the one to pay
+			payments.CancelIntent(r.Context(), intent.ID)
+			writeError(w, http.StatusConflict, "CHECKOUT_IN_PROGRESS", "Checkout for this reservation is already in progress; retry to get its payment intent")
+			return
+		}
+	} else {
+		internalError(w, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Reservation confirmed",
+		"message":           "Checkout started",
+		"payment_intent_id": intent.ID,
+		"client_secret":     intent.ClientSecret,
+		"amount_cents":      intent.AmountCents,
+		"currency":          intent.Currency,
 	})
 }
 
 func main() {
+	flag.StringVar(&currency, "currency", "USD", "currency of ticket prices and payments")
+	provider := flag.String("payment-provider", "fake", "payment provider; only \"fake\" (in-memory, for local runs) exists yet")
+	fakeWebhookURL := flag.String("fakepay-webhook-url", "http://localhost:8080/webhooks/payments", "where the fake provider delivers webhooks")
+	fakeDropWebhooks := flag.Bool("fakepay-drop-webhooks", false, "make the fake provider lose every webhook, to exercise reconciliation")
+	reconcileInterval := flag.Duration("reconcile-interval", time.Minute, "how often to settle pending payments whose webhook may have been lost")
+	flag.Parse()
+
+	webhookSecret = []byte(os.Getenv("PAYMENT_WEBHOOK_SECRET"))
+	if len(webhookSecret) == 0 {
+		if *provider != "fake" {
+			log.Fatal("PAYMENT_WEBHOOK_SECRET is required")
+		}
+		// The fake provider runs in this process, so a throwaway secret will do
+		webhookSecret = make([]byte, 32)
+		rand.Read(webhookSecret)
+	}
+
+	switch *provider {
+	case "fake":
+		fake := newFakeProvider(*fakeWebhookURL, webhookSecret, *fakeDropWebhooks)
+		payments = fake
+		http.HandleFunc("POST /fakepay/intents/{id}/confirm", fake.confirmIntent)
+	default:
+		log.Fatalf("unknown payment provider %q", *provider)
+	}
+
 	initDB()
 	initRedis()
 
 	http.HandleFunc("/reserve", reserveTicket)
 	http.HandleFunc("/confirm", confirmReservation)
+	http.HandleFunc("GET /payments/{id}", getPayment)
+	http.HandleFunc("POST /webhooks/payments", paymentWebhook)
+
+	go reconcilePayments(*reconcileInterval)
 
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
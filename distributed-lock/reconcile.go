@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// payment is one row of the payments table.
+type payment struct {
+	IntentID    string    `json:"payment_intent_id"`
+	TicketID    string    `json:"ticket_id"`
+	UserID      string    `json:"user_id"`
+	AmountCents int64     `json:"amount_cents"`
+	Currency    string    `json:"currency"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// loadPayment reads a payment. With forUpdate the row is locked until tx
+// ends. Returns sql.ErrNoRows if there is none.
+func loadPayment(q queryer, intentID string, forUpdate bool) (*payment, error) {
+	query := `SELECT intent_id, ticket_id, user_id, amount_cents, currency, status, created_at, updated_at
+		FROM payments WHERE intent_id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var p payment
+	err := q.QueryRow(query, intentID).Scan(&p.IntentID, &p.TicketID, &p.UserID, &p.AmountCents, &p.Currency, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func setPaymentStatus(tx *sql.Tx, p *payment, status string) error {
+	_, err := tx.Exec(`UPDATE payments SET status = $2, updated_at = NOW() WHERE intent_id = $1`, p.IntentID, status)
+	if err != nil {
+		return err
+	}
+	p.Status = status
+	return nil
+}
+
+// holdsTicket reports whether the user who opened p still holds the ticket
+// lock, and for how much longer.
+func holdsTicket(p *payment) (bool, time.Duration, error) {
+	lockKey := fmt.Sprintf("ticket_lock:%s", p.TicketID)
+	storedUserID, err := rdb.Get(ctx, lockKey).Result()
+	if err == redis.Nil {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("verify reservation: %w", err)
+	}
+	if storedUserID != p.UserID {
+		return false, 0, nil
+	}
+	ttl, err := rdb.PTTL(ctx, lockKey).Result()
+	if err != nil {
+		return false, 0, fmt.Errorf("verify reservation: %w", err)
+	}
+	return true, ttl, nil
+}
+
+// keepHoldScript extends a ticket lock to at least ARGV[2] ms if ARGV[1]
+// still holds it, and reports whether it does.
+var keepHoldScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+		redis.call("pexpire", KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0`)
+
+// releaseHoldScript deletes a ticket lock only if ARGV[1] still holds it, so
+// a lock taken by the next user after ours expired is left alone.
+var releaseHoldScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// bookingLease is how long a hold is kept alive while its ticket is booked.
+const bookingLease = 30 * time.Second
+
+// keepHold checks that the user who opened p still holds the ticket lock
+// and keeps it from expiring for bookingLease.
+func keepHold(p *payment) (bool, error) {
+	held, err := keepHoldScript.Run(ctx, rdb, []string{fmt.Sprintf("ticket_lock:%s", p.TicketID)},
+		p.UserID, bookingLease.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("verify reservation: %w", err)
+	}
+	return held == 1, nil
+}
+
+// releaseHold removes the ticket lock if the user who opened p still holds
+// it. Called once the payment is settled either way.
+func releaseHold(p *payment) {
+	err := releaseHoldScript.Run(ctx, rdb, []string{fmt.Sprintf("ticket_lock:%s", p.TicketID)}, p.UserID).Err()
+	if err != nil && err != redis.Nil {
+		log.Printf("Error releasing hold on ticket %s: %v", p.TicketID, err)
+	}
+}
+
+// refundPayment gives the money of a succeeded intent back.
+func refundPayment(ctx context.Context, tx *sql.Tx, p *payment) error {
+	if err := payments.RefundIntent(ctx, p.IntentID); err != nil {
+		return fmt.Errorf("refund payment intent %s: %w", p.IntentID, err)
+	}
+	return setPaymentStatus(tx, p, "REFUNDED")
+}
+
+// reconcilePayment brings a locked payment in line with the state of its
+// intent at the provider. Webhooks and the reconciliation job both end up
+// here, so it must be safe to run any number of times:
+//
+//	payment           intent            result
+//	PENDING           succeeded         SUCCEEDED and ticket BOOKED, or REFUNDED if the hold is gone
+//	PENDING           failed            FAILED
+//	PENDING           canceled          CANCELLED
+//	PENDING           refunded          REFUNDED
+//	PENDING           requires_payment  intent canceled and CANCELLED once the hold is gone
+//	SUCCEEDED         refunded          REFUNDED and ticket AVAILABLE
+//	FAILED/CANCELLED  succeeded         REFUNDED (paid after we gave up)
+//
+// The caller releases the hold after committing if p is no longer PENDING.
+func reconcilePayment(ctx context.Context, tx *sql.Tx, p *payment) error {
+	intent, err := payments.GetIntent(ctx, p.IntentID)
+	if err != nil {
+		return fmt.Errorf("get payment intent %s: %w", p.IntentID, err)
+	}
+
+	switch p.Status {
+	case "PENDING":
+		switch intent.Status {
+		case IntentSucceeded:
+			// The lease keeps the hold from lapsing while we book. Redis and
+			// Postgres cannot commit together though, so what really stops a
+			// double booking is the guarded UPDATE: the ticket row only goes
+			// to BOOKED from another status, under its row lock, so of two
+			// payers racing for one seat exactly one books it and the other
+			// is refunded below.
+			held, err := keepHold(p)
+			if err != nil {
+				return err
+			}
+			if held {
+				res, err := tx.Exec(`UPDATE tickets SET status = 'BOOKED', user_id = $1 WHERE id = $2 AND status <> 'BOOKED'`, p.UserID, p.TicketID)
+				if err != nil {
+					return err
+				}
+				if n, _ := res.RowsAffected(); n == 1 {
+					return setPaymentStatus(tx, p, "SUCCEEDED")
+				}
+			}
+			// The seat may be someone else's by now
+			return refundPayment(ctx, tx, p)
+
+		case IntentFailed:
+			return setPaymentStatus(tx, p, "FAILED")
+		case IntentCanceled:
+			return setPaymentStatus(tx, p, "CANCELLED")
+		case IntentRefunded:
+			return setPaymentStatus(tx, p, "REFUNDED")
+
+		case IntentRequiresPayment:
+			held, _, err := holdsTicket(p)
+			if err != nil || held {
+				return err
+			}
+			err = payments.CancelIntent(ctx, p.IntentID)
+			if err == errIntentSucceeded {
+				// Paid just now, but the hold is gone
+				return refundPayment(ctx, tx, p)
+			}
+			if err != nil {
+				return fmt.Errorf("cancel payment intent %s: %w", p.IntentID, err)
+			}
+			return setPaymentStatus(tx, p, "CANCELLED")
+		}
+
+	case "SUCCEEDED":
+		if intent.Status == IntentRefunded {
+			_, err := tx.Exec(`UPDATE tickets SET status = 'AVAILABLE', user_id = NULL WHERE id = $1 AND user_id = $2`, p.TicketID, p.UserID)
+			if err != nil {
+				return err
+			}
+			return setPaymentStatus(tx, p, "REFUNDED")
+		}
+
+	case "FAILED", "CANCELLED":
+		if intent.Status == IntentSucceeded {
+			return refundPayment(ctx, tx, p)
+		}
+	}
+	return nil
+}
+
+// reconcilePayments runs forever. A webhook can be lost, so every interval
+// it asks the provider about each PENDING payment whose hold is gone or
+// runs out before the next run, and settles it as the webhook would have.
+func reconcilePayments(interval time.Duration) {
+	for range time.Tick(interval) {
+		rows, err := db.Query(`SELECT intent_id, ticket_id, user_id FROM payments WHERE status = 'PENDING'`)
+		if err != nil {
+			log.Println("Error finding payments to reconcile:", err)
+			continue
+		}
+		var pending []*payment
+		for rows.Next() {
+			var p payment
+			if err := rows.Scan(&p.IntentID, &p.TicketID, &p.UserID); err != nil {
+				log.Println("Error scanning row:", err)
+				continue
+			}
+			pending = append(pending, &p)
+		}
+		rows.Close()
+
+		for _, p := range pending {
+			held, ttl, err := holdsTicket(p)
+			if err != nil {
+				log.Printf("Error reconciling payment %s: %v", p.IntentID, err)
+				continue
+			}
+			if held && ttl > interval {
+				continue
+			}
+			if err := reconcileIntent(p.IntentID); err != nil {
+				log.Printf("Error reconciling payment %s: %v", p.IntentID, err)
+			}
+		}
+	}
+}
+
+func reconcileIntent(intentID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := loadPayment(tx, intentID, true)
+	if err != nil {
+		return err
+	}
+	if p.Status != "PENDING" {
+		// A webhook got here first
+		return nil
+	}
+	if err := reconcilePayment(context.Background(), tx, p); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if p.Status != "PENDING" {
+		releaseHold(p)
+	}
+	return nil
+}
+
+// getPayment -> GET /payments/{id}
+// Lets the client follow a checkout until the webhook settles it.
+func getPayment(w http.ResponseWriter, r *http.Request) {
+	p, err := loadPayment(db, r.PathValue("id"), false)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "PAYMENT_NOT_FOUND", "Payment not found")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The provider tells us how a payment ended by POSTing an event to
+// /webhooks/payments, signed with a secret only the two of us know:
+//
+//	Payment-Signature: t=1700000000,v1=<hex HMAC-SHA256 of "1700000000." + body>
+//
+// Signatures older than webhookTolerance are refused, so a captured event
+// cannot be replayed later. Providers deliver at least once: each event ID
+// is recorded in webhook_events in the same transaction that acts on it,
+// and a redelivery is acknowledged without doing anything again.
+//
+// An event only says which intent changed. Its current state is read back
+// from the provider, so events arriving out of order cannot undo each other.
+
+const (
+	webhookSignatureHeader = "Payment-Signature"
+	webhookTolerance       = 5 * time.Minute
+)
+
+const (
+	EventIntentSucceeded = "payment_intent.succeeded"
+	EventIntentFailed    = "payment_intent.failed"
+	EventIntentCanceled  = "payment_intent.canceled"
+	EventIntentRefunded  = "payment_intent.refunded"
+)
+
+// webhookEvent is the body of a webhook call.
+type webhookEvent struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Created int64          `json:"created"` // unix seconds
+	Data    *PaymentIntent `json:"data"`
+}
+
+// webhookSecret is shared with the provider; set from
+// PAYMENT_WEBHOOK_SECRET in main.
+var webhookSecret []byte
+
+var errBadSignature = errors.New("invalid webhook signature")
+
+// signWebhook returns the Payment-Signature header for body sent at
+// timestamp.
+func signWebhook(secret []byte, timestamp int64, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(webhookMAC(secret, timestamp, body)))
+}
+
+func webhookMAC(secret []byte, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// verifyWebhook checks a Payment-Signature header against body. Any of
+// several v1 signatures may match, so the secret can be rotated.
+func verifyWebhook(secret []byte, header string, body []byte, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return errBadSignature
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return errBadSignature
+	}
+
+	expected := webhookMAC(secret, timestamp, body)
+	for _, signature := range signatures {
+		got, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+	return errBadSignature
+}
+
+// paymentWebhook -> POST /webhooks/payments
+// Books the ticket or releases the hold of the intent named in the event.
+// Any non-2xx response makes the provider deliver the event again.
+func paymentWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+		return
+	}
+	if err := verifyWebhook(webhookSecret, r.Header.Get(webhookSignatureHeader), body, time.Now()); err != nil {
+		writeError(w, http.StatusUnauthorized, "INVALID_SIGNATURE", "Invalid or expired webhook signature")
+		return
+	}
+
+	var event webhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" || event.Data == nil || event.Data.ID == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid webhook event")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO webhook_events (id, type, intent_id) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING`,
+		event.ID, event.Type, event.Data.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Delivered before
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var p *payment
+	switch event.Type {
+	case EventIntentSucceeded, EventIntentFailed, EventIntentCanceled, EventIntentRefunded:
+		p, err = loadPayment(tx, event.Data.ID, true)
+		if err == sql.ErrNoRows {
+			// Checkout failed to record the intent it opened
+			log.Printf("webhook %s: no payment for intent %s", event.ID, event.Data.ID)
+			break
+		}
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if err := reconcilePayment(r.Context(), tx, p); err != nil {
+			internalError(w, err)
+			return
+		}
+	default:
+		log.Printf("webhook %s: ignoring event type %q", event.ID, event.Type)
+	}
+
+	if err := tx.Commit(); err != nil {
+		internalError(w, err)
+		return
+	}
+	if p != nil && p.Status != "PENDING" {
+		releaseHold(p)
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -18,7 +18,7 @@ type SearchRequest struct {
 func SearchEvents(w http.ResponseWriter, r *http.Request) {
 	var req SearchRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
 		return
 	}
 
@@ -27,7 +27,9 @@ func SearchEvents(w http.ResponseWriter, r *http.Request) {
 		elastic.SetSniff(false),
 	)
 	if err != nil {
-		log.Fatalf("Error creating the client: %s", err)
+		log.Printf("Error creating the client: %s", err)
+		writeError(w, http.StatusServiceUnavailable, "SEARCH_UNAVAILABLE", "Search is temporarily unavailable")
+		return
 	}
 
 	query := elastic.NewBoolQuery()
@@ -48,7 +50,7 @@ func SearchEvents(w http.ResponseWriter, r *http.Request) {
 		Do(context.Background())
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 
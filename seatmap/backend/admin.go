@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// SEAT LAYOUTS
+// ----------------------------------------------------------------------
+
+// SeatLayout describes how to generate the seats of an event: each section
+// gets Rows rows numbered from 1, each with SeatsPerRow seats numbered from 1.
+type SeatLayout struct {
+	Sections []SectionLayout `json:"sections"`
+}
+
+// SectionLayout is one block of identically sized rows.
+type SectionLayout struct {
+	Name        string `json:"name"`
+	Rows        int    `json:"rows"`
+	SeatsPerRow int    `json:"seats_per_row"`
+}
+
+// maxSeatsPerEvent keeps a typo in a layout from allocating millions of seats.
+const maxSeatsPerEvent = 100000
+
+// Validate checks that a layout can be turned into seats.
+func (l SeatLayout) Validate() error {
+	if len(l.Sections) == 0 {
+		return invalidRequest("layout needs at least one section")
+	}
+	names := make(map[string]bool, len(l.Sections))
+	total := 0
+	for _, sec := range l.Sections {
+		if strings.TrimSpace(sec.Name) == "" {
+			return invalidRequest("every section needs a name")
+		}
+		if names[sec.Name] {
+			return invalidRequest(fmt.Sprintf("section %q listed twice", sec.Name))
+		}
+		names[sec.Name] = true
+		if sec.Rows <= 0 || sec.SeatsPerRow <= 0 {
+			return invalidRequest(fmt.Sprintf("section %q needs positive rows and seats_per_row", sec.Name))
+		}
+		total += sec.Rows * sec.SeatsPerRow
+		if total > maxSeatsPerEvent {
+			return invalidRequest(fmt.Sprintf("layout exceeds %d seats", maxSeatsPerEvent))
+		}
+	}
+	return nil
+}
+
+// generateSeats expands a validated layout into unsaved seats. Sections are
+// drawn one below the other with an empty row between them, one unit per
+// seat, and the first and last seat of every row are marked as aisle seats.
+func generateSeats(layout SeatLayout) []*Seat {
+	var seats []*Seat
+	offset := 0
+	for _, sec := range layout.Sections {
+		for row := 1; row <= sec.Rows; row++ {
+			for number := 1; number <= sec.SeatsPerRow; number++ {
+				seats = append(seats, &Seat{
+					Row:            row,
+					Number:         number,
+					Section:        sec.Name,
+					X:              float64(number),
+					Y:              float64(offset + row),
+					Status:         StatusAvailable,
+					SeatAttributes: SeatAttributes{Aisle: number == 1 || number == sec.SeatsPerRow},
+				})
+			}
+		}
+		offset += sec.Rows + 1
+	}
+	return seats
+}
+
+// ----------------------------------------------------------------------
+// ADMIN AUTHORIZATION
+// ----------------------------------------------------------------------
+
+// adminUsers lists the user IDs allowed to call /admin endpoints.
+var adminUsers = make(map[int64]bool)
+
+// parseAdminUsers reads a comma-separated list of user IDs.
+func parseAdminUsers(list string) (map[int64]bool, error) {
+	admins := make(map[int64]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin user ID %q", field)
+		}
+		admins[id] = true
+	}
+	return admins, nil
+}
+
+// requireAdmin returns the caller's user ID if they are an admin, or writes
+// a 401/403 response.
+func requireAdmin(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return 0, false
+	}
+	if !adminUsers[userID] {
+		writeError(w, ErrAdminRequired)
+		return 0, false
+	}
+	return userID, true
+}
+
+// ----------------------------------------------------------------------
+// EVENT HANDLERS
+// ----------------------------------------------------------------------
+
+// eventRequest is the body of POST /admin/events and PUT /admin/events/{id}.
+// On create, seats come from either Layout or the layout of venue VenueID.
+type eventRequest struct {
+	Name      string         `json:"name"`
+	Venue     string         `json:"venue"`
+	VenueID   int64          `json:"venue_id,omitempty"`
+	StartTime time.Time      `json:"start_time"`
+	Layout    *SeatLayout    `json:"layout,omitempty"`   // only used on create
+	Settings  *EventSettings `json:"settings,omitempty"` // left unchanged on update when omitted
+}
+
+func (req eventRequest) validate() error {
+	if strings.TrimSpace(req.Name) == "" {
+		return invalidRequest("event name is required")
+	}
+	if req.StartTime.IsZero() {
+		return invalidRequest("event start_time is required")
+	}
+	if req.Settings != nil {
+		if err := req.Settings.Validate(); err != nil {
+			return err
+		}
+	}
+	if req.Layout != nil && req.VenueID != 0 {
+		return invalidRequest("give either layout or venue_id, not both")
+	}
+	if req.Layout != nil {
+		return req.Layout.Validate()
+	}
+	return nil
+}
+
+// seatSource is the body of POST /admin/events/{id}/seats: either a venue
+// to copy the layout from or an inline SeatLayout.
+type seatSource struct {
+	VenueID int64 `json:"venue_id,omitempty"`
+	SeatLayout
+}
+
+// resolveSeats builds unsaved seats from a venue or an inline layout. It
+// returns a nil venue when the seats come from the inline layout.
+func resolveSeats(venueID int64, layout *SeatLayout) ([]*Seat, *Venue, error) {
+	if venueID != 0 {
+		v, err := store.GetVenue(venueID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return seatsFromVenue(v), v, nil
+	}
+	if layout != nil {
+		return generateSeats(*layout), nil, nil
+	}
+	return nil, nil, nil
+}
+
+// eventWithSeats is returned when an admin call also (re)generates seats.
+type eventWithSeats struct {
+	*Event
+	Seats []*Seat `json:"seats"`
+}
+
+// listEventsHandler -> GET /events
+func listEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events, err := store.ListEvents()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// getEventHandler -> GET /events/{eventID}
+func getEventHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, ok := parseIDSegment(w, r, 1, "event")
+	if !ok {
+		return
+	}
+	e, err := store.GetEvent(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// createEventHandler -> POST /admin/events
+// Creates an event and, if a layout is given, its seats.
+func createEventHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	var req eventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	seats, venue, err := resolveSeats(req.VenueID, req.Layout)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	event := &Event{Name: req.Name, Venue: req.Venue, StartTime: req.StartTime}
+	if req.Settings != nil {
+		event.Settings = *req.Settings
+	}
+	if venue != nil {
+		event.VenueID = venue.ID
+		if event.Venue == "" {
+			event.Venue = venue.Name
+		}
+	}
+
+	// One call, so a failure cannot leave an event without its seats
+	e, created, err := store.CreateEvent(event, seats)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := eventWithSeats{Event: e}
+	if seats != nil {
+		result.Seats = created
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// updateEventHandler -> PUT /admin/events/{eventID}
+func updateEventHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	eventID, ok := parseIDSegment(w, r, 2, "event")
+	if !ok {
+		return
+	}
+	var req eventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	// seats are regenerated through /admin/events/{id}/seats
+	req.Layout, req.VenueID = nil, 0
+	if err := req.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	existing, err := store.GetEvent(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	settings := existing.Settings
+	if req.Settings != nil {
+		settings = *req.Settings
+	}
+	e, err := store.UpdateEvent(&Event{ID: eventID, Name: req.Name, Venue: req.Venue, VenueID: existing.VenueID, StartTime: req.StartTime, Settings: settings})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// updateEventSettingsHandler -> PUT /admin/events/{eventID}/settings
+// Replaces the sales rules of an event: seat ranking, single-seat gaps and
+// per-user limits. Limits apply to new reservations only.
+func updateEventSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	eventID, ok := parseIDSegment(w, r, 2, "event")
+	if !ok {
+		return
+	}
+	var settings EventSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	e, err := store.GetEvent(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	e.Settings = settings
+	updated, err := store.UpdateEvent(e)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deleteEventHandler -> DELETE /admin/events/{eventID}
+func deleteEventHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	eventID, ok := parseIDSegment(w, r, 2, "event")
+	if !ok {
+		return
+	}
+	if err := store.DeleteEvent(eventID); err != nil {
+		writeError(w, err)
+		return
+	}
+	broadcastSeatMap(eventID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// generateSeatsHandler -> POST /admin/events/{eventID}/seats
+// Replaces the seats of an event with ones generated from an inline layout
+// or copied from a venue given as {"venue_id": N}.
+func generateSeatsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	eventID, ok := parseIDSegment(w, r, 2, "event")
+	if !ok {
+		return
+	}
+	var src seatSource
+	if err := json.NewDecoder(r.Body).Decode(&src); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	if src.VenueID == 0 {
+		if err := src.SeatLayout.Validate(); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	newSeats, _, err := resolveSeats(src.VenueID, &src.SeatLayout)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	seats, err := store.ReplaceEventSeats(eventID, newSeats)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Remember which venue the seats now come from
+	if e, err := store.GetEvent(eventID); err == nil && e.VenueID != src.VenueID {
+		e.VenueID = src.VenueID
+		if _, err := store.UpdateEvent(e); err != nil {
+			log.Printf("failed to record venue of event %d: %v", eventID, err)
+		}
+	}
+	broadcastSeatMap(eventID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(seats)
+}
+
+// adminEventsRouter dispatches /admin/events and /admin/events/...
+func adminEventsRouter(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path)
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		createEventHandler(w, r)
+	case len(parts) == 3 && r.Method == http.MethodPut:
+		updateEventHandler(w, r)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		deleteEventHandler(w, r)
+	case len(parts) == 4 && parts[3] == "seats" && r.Method == http.MethodPost:
+		generateSeatsHandler(w, r)
+	case len(parts) == 4 && parts[3] == "settings" && r.Method == http.MethodPut:
+		updateEventSettingsHandler(w, r)
+	case len(parts) == 4 && parts[3] == "price-tiers" && r.Method == http.MethodPost:
+		createPriceTierHandler(w, r)
+	case len(parts) == 5 && parts[3] == "price-tiers" && r.Method == http.MethodPut:
+		updatePriceTierHandler(w, r)
+	case len(parts) == 4 && parts[3] == "prices" && r.Method == http.MethodPost:
+		assignPricesHandler(w, r)
+	default:
+		routeNotFound(w, r)
+	}
+}
+
+// parseIDSegment parses the numeric path segment at index i or writes a 400.
+func parseIDSegment(w http.ResponseWriter, r *http.Request, i int, name string) (int64, bool) {
+	parts := splitPath(r.URL.Path)
+	if len(parts) <= i {
+		writeError(w, invalidRequest("invalid path"))
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[i], 10, 64)
+	if err != nil {
+		writeError(w, invalidRequest("invalid "+name+" ID"))
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// BEARER TOKENS
+// ----------------------------------------------------------------------
+//
+// Tokens are HS256 JWTs: base64url(header).base64url(claims).base64url(sig),
+// where sig = HMAC-SHA256(secret, header + "." + claims). The "sub" claim
+// carries the numeric user ID and "exp" the unix expiry time.
+
+var (
+	ErrMissingToken = newError(http.StatusUnauthorized, "MISSING_TOKEN", "missing bearer token")
+	ErrInvalidToken = newError(http.StatusUnauthorized, "INVALID_TOKEN", "invalid bearer token")
+	ErrTokenExpired = newError(http.StatusUnauthorized, "TOKEN_EXPIRED", "bearer token expired")
+)
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type tokenClaims struct {
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// issueToken signs a token for userID that is valid for ttl.
+func issueToken(secret []byte, userID int64, ttl time.Duration) (string, error) {
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(tokenClaims{
+		Sub: strconv.FormatInt(userID, 10),
+		Exp: time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(claims)
+	return signingInput + "." + b64.EncodeToString(signToken(secret, signingInput)), nil
+}
+
+// parseToken verifies the signature and expiry of a token and returns its user ID.
+func parseToken(secret []byte, token string, now time.Time) (int64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, ErrInvalidToken
+	}
+
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, signToken(secret, parts[0]+"."+parts[1])) {
+		return 0, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return 0, ErrInvalidToken
+	}
+	var claims tokenClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return 0, ErrInvalidToken
+	}
+	if claims.Exp == 0 || now.Unix() >= claims.Exp {
+		return 0, ErrTokenExpired
+	}
+
+	userID, err := strconv.ParseInt(claims.Sub, 10, 64)
+	if err != nil || userID <= 0 {
+		return 0, ErrInvalidToken
+	}
+	return userID, nil
+}
+
+func signToken(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func decodeTokenPart(part string, v any) error {
+	data, err := b64.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ----------------------------------------------------------------------
+// MIDDLEWARE
+// ----------------------------------------------------------------------
+
+type contextKey string
+
+const userIDContextKey contextKey = "userID"
+
+// authMiddleware puts the caller's user ID in the request context when the
+// request carries a valid "Authorization: Bearer <token>" header. Requests
+// without the header pass through anonymously so public reads keep working;
+// a header with a bad token is rejected right away.
+//
+// Browsers cannot set headers on WebSocket handshakes, so those may pass the
+// token as the access_token query parameter instead.
+func authMiddleware(secret []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := bearerToken(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := parseToken(secret, token, time.Now())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDContextKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bearerToken returns the token of a request, or "" if it carries none.
+func bearerToken(r *http.Request) (string, error) {
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		token, ok := strings.CutPrefix(authz, "Bearer ")
+		if !ok {
+			return "", ErrInvalidToken
+		}
+		return strings.TrimSpace(token), nil
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("access_token"), nil
+	}
+	return "", nil
+}
+
+// userIDFromContext returns the authenticated user ID, if any.
+func userIDFromContext(ctx context.Context) (int64, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(int64)
+	return userID, ok
+}
+
+// requireUser returns the authenticated user ID or writes a 401 response.
+func requireUser(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="seatmap"`)
+		writeError(w, ErrMissingToken)
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ----------------------------------------------------------------------
+// BACKPLANE
+// ----------------------------------------------------------------------
+//
+// SSEManager only reaches clients connected to this process. When several
+// backend instances share one Postgres store, a seat booked through one
+// must still reach the streams served by the others. A Backplane carries
+// seat updates between instances; each instance feeds them into its own
+// SeatFeed, which versions them and fans them out through its SSEManager.
+//
+// Updates travel as seat states rather than finished SSE messages because
+// versions and SSE ids are per instance: a client that reconnects to another
+// instance gets a fresh snapshot instead of a replay.
+//
+// Without a backplane (the default) updates stay in process.
+
+// SeatUpdate is what instances tell each other after seats change.
+type SeatUpdate struct {
+	Origin  string  `json:"origin"` // instance that made the change
+	EventID int64   `json:"event_id"`
+	Seats   []*Seat `json:"seats,omitempty"`   // new seat states
+	Refresh bool    `json:"refresh,omitempty"` // reload every seat of the event
+}
+
+// Backplane carries seat updates between backend instances.
+type Backplane interface {
+	// Publish sends an update to the other instances.
+	Publish(u SeatUpdate)
+	// Run hands updates from other instances to feed until ctx is done.
+	Run(ctx context.Context, feed *SeatFeed)
+}
+
+// seatUpdatesChannel is the Redis pub/sub channel all instances share.
+const seatUpdatesChannel = "seatmap:seat-updates"
+
+// redisPublishTimeout bounds how long a request waits to hand an update to
+// Redis before giving up on telling the other instances.
+const redisPublishTimeout = 2 * time.Second
+
+// RedisBackplane is a Backplane on Redis pub/sub. Pub/sub does not keep
+// messages for disconnected subscribers, so after every (re)subscription
+// the instance reloads the events it is serving.
+type RedisBackplane struct {
+	client *redis.Client
+	origin string
+}
+
+// NewRedisBackplane connects to Redis. origin identifies this instance, so
+// it can skip its own updates.
+func NewRedisBackplane(addr, origin string) (*RedisBackplane, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisBackplane{client: client, origin: origin}, nil
+}
+
+func (b *RedisBackplane) Publish(u SeatUpdate) {
+	u.Origin = b.origin
+	data, err := json.Marshal(u)
+	if err != nil {
+		log.Printf("failed to encode seat update: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisPublishTimeout)
+	defer cancel()
+	if err := b.client.Publish(ctx, seatUpdatesChannel, data).Err(); err != nil {
+		log.Printf("failed to publish seat update of event %d: %v", u.EventID, err)
+	}
+}
+
+func (b *RedisBackplane) Run(ctx context.Context, feed *SeatFeed) {
+	sub := b.client.Subscribe(ctx, seatUpdatesChannel)
+	defer sub.Close()
+
+	messages := sub.ChannelWithSubscriptions(ctx, 100)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				// Anything published while we were away is lost
+				feed.refreshAll()
+			case *redis.Message:
+				var u SeatUpdate
+				if err := json.Unmarshal([]byte(msg.Payload), &u); err != nil {
+					log.Printf("ignoring bad seat update: %v", err)
+					continue
+				}
+				if u.Origin != b.origin {
+					feed.receive(u)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// ----------------------------------------------------------------------
+// BEST AVAILABLE
+// ----------------------------------------------------------------------
+
+// SeatRanking says which seats a best-available search offers first:
+// better sections first, then rows closer to the best row, then blocks
+// closer to the middle of their row.
+type SeatRanking struct {
+	// Sections lists section names from best to worst. Sections that are
+	// not listed rank after all listed ones.
+	Sections []string `json:"sections,omitempty"`
+	// BestRow is the row with the best view, 1 (the front) if unset. Rows
+	// rank by their distance from it, so 3 prefers 3, then 2 and 4, ...
+	BestRow int `json:"best_row,omitempty"`
+}
+
+// Validate checks a ranking before it is stored.
+func (r *SeatRanking) Validate() error {
+	if r.BestRow < 0 {
+		return invalidRequest("best_row must not be negative")
+	}
+	seen := make(map[string]bool, len(r.Sections))
+	for _, name := range r.Sections {
+		if seen[name] {
+			return invalidRequest(fmt.Sprintf("section %q ranked twice", name))
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// bestAvailableAttempts is how many blocks are tried when faster buyers
+// keep taking the best ones between the search and the hold.
+const bestAvailableAttempts = 5
+
+// bestAvailableRequest is the body of POST /events/{id}/best-available
+type bestAvailableRequest struct {
+	Quantity    int    `json:"quantity"`
+	Section     string `json:"section,omitempty"`       // only search this section
+	PriceTierID int64  `json:"price_tier_id,omitempty"` // only search seats of this tier
+	Duration    int    `json:"duration"`                // as in POST /reservation-groups
+}
+
+// seatBlock is a run of adjacent available seats in one row with its rank.
+type seatBlock struct {
+	seats        []*Seat
+	sectionRank  int
+	rowDistance  int
+	centerOffset float64
+}
+
+// findSeatBlocks returns every block of quantity adjacent available seats
+// matching the request, best first. Seats are adjacent when they share a
+// section and row and their numbers are consecutive. Blocks that would
+// leave a single-seat gap are skipped if the event forbids those.
+func findSeatBlocks(seats []*Seat, req bestAvailableRequest, settings EventSettings) []seatBlock {
+	var ranking SeatRanking
+	if settings.SeatRanking != nil {
+		ranking = *settings.SeatRanking
+	}
+
+	type rowKey struct {
+		section string
+		row     int
+	}
+	rows := make(map[rowKey][]*Seat)
+	for _, seat := range seats {
+		if req.Section != "" && seat.Section != req.Section {
+			continue
+		}
+		key := rowKey{seat.Section, seat.Row}
+		rows[key] = append(rows[key], seat)
+	}
+
+	sectionRank := make(map[string]int, len(ranking.Sections))
+	for i, name := range ranking.Sections {
+		sectionRank[name] = i
+	}
+	bestRow := ranking.BestRow
+	if bestRow == 0 {
+		bestRow = 1
+	}
+
+	usable := func(seat *Seat) bool {
+		return seat.Status == StatusAvailable &&
+			(req.PriceTierID == 0 || seat.PriceTierID == req.PriceTierID)
+	}
+
+	var blocks []seatBlock
+	for key, row := range rows {
+		sort.Slice(row, func(i, j int) bool { return row[i].Number < row[j].Number })
+		rank, ok := sectionRank[key.section]
+		if !ok {
+			rank = len(ranking.Sections)
+		}
+		rowCenter := (row[0].X + row[len(row)-1].X) / 2
+
+		for start := 0; start+req.Quantity <= len(row); start++ {
+			block := row[start : start+req.Quantity]
+			fits := true
+			sumX := 0.0
+			for i, seat := range block {
+				if !usable(seat) || (i > 0 && seat.Number != block[i-1].Number+1) {
+					fits = false
+					break
+				}
+				sumX += seat.X
+			}
+			if !fits || (settings.NoSingleSeatGaps && findOrphanedSeat(block, row) != nil) {
+				continue
+			}
+			blocks = append(blocks, seatBlock{
+				seats:        block,
+				sectionRank:  rank,
+				rowDistance:  abs(key.row - bestRow),
+				centerOffset: math.Abs(sumX/float64(len(block)) - rowCenter),
+			})
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		switch {
+		case a.sectionRank != b.sectionRank:
+			return a.sectionRank < b.sectionRank
+		case a.rowDistance != b.rowDistance:
+			return a.rowDistance < b.rowDistance
+		case a.seats[0].Row != b.seats[0].Row:
+			return a.seats[0].Row < b.seats[0].Row // front row wins a tie
+		case a.centerOffset != b.centerOffset:
+			return a.centerOffset < b.centerOffset
+		case a.seats[0].Section != b.seats[0].Section:
+			return a.seats[0].Section < b.seats[0].Section
+		default:
+			return a.seats[0].Number < b.seats[0].Number
+		}
+	})
+	return blocks
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// bestAvailableResult is the held group together with its seats.
+type bestAvailableResult struct {
+	*ReservationGroup
+	Seats []*Seat `json:"seats"`
+}
+
+// bestAvailableHandler -> POST /events/{eventID}/best-available
+// Body: {"quantity": 4, "section": "Floor", "price_tier_id": 1, "duration": 300}
+// Finds the best block of adjacent available seats and holds it as one
+// reservation group.
+func bestAvailableHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, ok := parseIDSegment(w, r, 1, "event")
+	if !ok {
+		return
+	}
+	var req bestAvailableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	if req.Quantity <= 0 {
+		writeError(w, ErrNoSeatsRequested)
+		return
+	}
+	if req.Quantity > maxSeatsPerGroup {
+		writeError(w, ErrTooManySeats)
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	event, err := store.GetEvent(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	seats, err := store.GetAllSeatsForEvent(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	blocks := findSeatBlocks(seats, req, event.Settings)
+
+	// The search ran on a snapshot, so a block may be gone by the time we
+	// try to hold it; move on to the next best one.
+	for i := 0; i < len(blocks) && i < bestAvailableAttempts; i++ {
+		seatIDs := make([]int64, len(blocks[i].seats))
+		for j, seat := range blocks[i].seats {
+			seatIDs[j] = seat.ID
+		}
+
+		group, err := store.ReserveSeats(seatIDs, userID, holdDuration(req.Duration))
+		var orphan *OrphanSeatError
+		if err == ErrSeatNotAvailable || errors.As(err, &orphan) {
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		seatFeed.Publish(group.EventID, group.SeatIDs...)
+
+		result := bestAvailableResult{ReservationGroup: group}
+		for _, seatID := range group.SeatIDs {
+			if seat := getSeatByID(seatID); seat != nil {
+				result.Seats = append(result.Seats, seat)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	writeError(w, ErrNoAdjacentSeats)
+}
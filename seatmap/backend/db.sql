@@ -0,0 +1,134 @@
+-- Same events/tickets/reservations layout as db-row-lock/db.sql, with integer
+-- ids (the seatmap API addresses seats by number) and the seat position split
+-- into row and number so the frontend can lay seats out.
+DROP TABLE IF EXISTS seat_events;
+DROP TABLE IF EXISTS reservations;
+DROP TABLE IF EXISTS reservation_groups;
+DROP TABLE IF EXISTS tickets;
+DROP TABLE IF EXISTS price_tiers;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS venue_seats;
+DROP TABLE IF EXISTS sections;
+DROP TABLE IF EXISTS venues;
+
+-- Venue layouts: events copy venue_seats into their own tickets.
+CREATE TABLE venues (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    width DOUBLE PRECISION NOT NULL DEFAULT 0,
+    height DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+
+CREATE TABLE sections (
+    id BIGSERIAL PRIMARY KEY,
+    venue_id BIGINT NOT NULL REFERENCES venues(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL DEFAULT '',
+    position INTEGER NOT NULL,
+    UNIQUE (venue_id, name)
+);
+
+CREATE TABLE venue_seats (
+    id BIGSERIAL PRIMARY KEY,
+    section_id BIGINT NOT NULL REFERENCES sections(id) ON DELETE CASCADE,
+    seat_row INTEGER NOT NULL,
+    seat_number INTEGER NOT NULL,
+    x DOUBLE PRECISION NOT NULL DEFAULT 0,
+    y DOUBLE PRECISION NOT NULL DEFAULT 0,
+    accessible BOOLEAN NOT NULL DEFAULT FALSE,
+    obstructed_view BOOLEAN NOT NULL DEFAULT FALSE,
+    aisle BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE (section_id, seat_row, seat_number)
+);
+
+CREATE TABLE events (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    date TIMESTAMP NOT NULL,
+    venue TEXT NOT NULL,
+    venue_id BIGINT REFERENCES venues(id),
+    settings JSONB NOT NULL DEFAULT '{}', -- EventSettings: ranking and sales rules
+    total_seats INTEGER NOT NULL,
+    available_seats INTEGER NOT NULL
+);
+
+CREATE TABLE price_tiers (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL REFERENCES events(id),
+    name TEXT NOT NULL,
+    price_cents BIGINT NOT NULL CHECK (price_cents >= 0),
+    currency TEXT NOT NULL DEFAULT 'USD'
+);
+
+CREATE TABLE tickets (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT REFERENCES events(id),
+    seat_row INTEGER NOT NULL,
+    seat_number INTEGER NOT NULL,
+    section TEXT NOT NULL DEFAULT '',
+    x DOUBLE PRECISION NOT NULL DEFAULT 0,
+    y DOUBLE PRECISION NOT NULL DEFAULT 0,
+    accessible BOOLEAN NOT NULL DEFAULT FALSE,
+    obstructed_view BOOLEAN NOT NULL DEFAULT FALSE,
+    aisle BOOLEAN NOT NULL DEFAULT FALSE,
+    price_tier_id BIGINT REFERENCES price_tiers(id),
+    status TEXT NOT NULL CHECK (status IN ('AVAILABLE', 'RESERVED', 'BOOKED')),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX tickets_event_id_idx ON tickets (event_id);
+
+-- A group holds several seats of one event under a single expiry.
+CREATE TABLE reservation_groups (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT REFERENCES events(id),
+    user_id BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('PENDING', 'CONFIRMED', 'CANCELLED', 'EXPIRED'))
+);
+
+CREATE TABLE reservations (
+    id BIGSERIAL PRIMARY KEY,
+    ticket_id BIGINT REFERENCES tickets(id),
+    user_id BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('PENDING', 'CONFIRMED', 'CANCELLED', 'EXPIRED')),
+    group_id BIGINT REFERENCES reservation_groups(id),
+    -- price paid, recorded when the reservation is confirmed
+    price_cents BIGINT
+);
+
+CREATE INDEX reservations_ticket_id_idx ON reservations (ticket_id);
+CREATE INDEX reservations_group_id_idx ON reservations (group_id);
+CREATE INDEX reservations_user_id_idx ON reservations (user_id);
+
+-- Append-only log of seat transitions, written in the same transaction as
+-- the change. The status of a ticket is the one its last entry leads to.
+CREATE TABLE seat_events (
+    id BIGSERIAL PRIMARY KEY,
+    ticket_id BIGINT NOT NULL REFERENCES tickets(id),
+    event_id BIGINT NOT NULL REFERENCES events(id),
+    type TEXT NOT NULL CHECK (type IN ('reserved', 'released', 'expired', 'booked')),
+    user_id BIGINT NOT NULL,  -- holder of the reservation
+    actor_id BIGINT,          -- who caused it; NULL when a hold timed out
+    reservation_id BIGINT NOT NULL REFERENCES reservations(id),
+    group_id BIGINT REFERENCES reservation_groups(id),
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX seat_events_ticket_id_idx ON seat_events (ticket_id, id);
+
+-- Seed 1 event and 5 tickets
+INSERT INTO events (id, name, date, venue, total_seats, available_seats)
+VALUES (1, 'Rock Concert 2025', '2025-12-31 20:00:00', 'Mega Stadium', 5, 5);
+
+INSERT INTO tickets (event_id, seat_row, seat_number, x, y, status)
+VALUES (1, 1, 1, 1, 1, 'AVAILABLE'),
+       (1, 1, 2, 2, 1, 'AVAILABLE'),
+       (1, 1, 3, 3, 1, 'AVAILABLE'),
+       (1, 1, 4, 4, 1, 'AVAILABLE'),
+       (1, 1, 5, 5, 1, 'AVAILABLE');
+
+SELECT setval('events_id_seq', (SELECT MAX(id) FROM events));
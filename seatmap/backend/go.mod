@@ -1,3 +1,15 @@
 module vnscriptkid/sd-ticketmaster/seatmap/backend
 
 go 1.22.4
+
+require github.com/lib/pq v1.10.9
+
+require (
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/gorilla/websocket v1.5.3
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+)
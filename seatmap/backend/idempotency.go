@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// IDEMPOTENT RETRIES
+// ----------------------------------------------------------------------
+//
+// Clients that time out on a reserve or book call cannot tell whether it
+// went through, so they retry. Without help the retry of a successful book
+// fails with "seat not reserved". A request carrying an Idempotency-Key
+// header is run once; repeats with the same key from the same user get the
+// first response again until idempotencyTTL has passed.
+//
+// Responses are kept in process memory, so replays only work while retries
+// reach the same instance.
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyReplayHeader marks responses that were replayed.
+	idempotencyReplayHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize bounds the request bodies read for fingerprinting.
+	maxIdempotentBodySize = 1 << 20
+)
+
+// idempotencyTTL is how long a stored response is replayed.
+var idempotencyTTL = 24 * time.Hour
+
+var idempotencyCache = NewIdempotencyCache()
+
+// idempotencyKey scopes a client key to its user and endpoint, so two users
+// (or two endpoints) never see each other's responses.
+type idempotencyKey struct {
+	userID int64
+	method string
+	path   string
+	key    string
+}
+
+// storedResponse is the response of the first request made with a key.
+type storedResponse struct {
+	fingerprint [sha256.Size]byte // query and body of that request
+	done        bool              // false while the first request still runs
+	status      int
+	header      http.Header
+	body        []byte
+	expiresAt   time.Time
+}
+
+// IdempotencyCache remembers responses by idempotency key.
+type IdempotencyCache struct {
+	mu      sync.Mutex
+	entries map[idempotencyKey]*storedResponse
+}
+
+func NewIdempotencyCache() *IdempotencyCache {
+	return &IdempotencyCache{entries: make(map[idempotencyKey]*storedResponse)}
+}
+
+// begin claims key for a request with the given fingerprint. It returns the
+// stored response to replay, or nil if the caller should run the request
+// and then call finish or forget.
+func (c *IdempotencyCache) begin(key idempotencyKey, fingerprint [sha256.Size]byte, now time.Time) (*storedResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && entry.done && !now.Before(entry.expiresAt) {
+		ok = false
+	}
+	if !ok {
+		c.entries[key] = &storedResponse{fingerprint: fingerprint}
+		return nil, nil
+	}
+	if entry.fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReuse
+	}
+	if !entry.done {
+		return nil, ErrIdempotencyKeyBusy
+	}
+	return entry, nil
+}
+
+// finish stores the response of a request claimed with begin.
+func (c *IdempotencyCache) finish(key idempotencyKey, status int, header http.Header, body []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.done = true
+		entry.status = status
+		entry.header = header
+		entry.body = body
+		entry.expiresAt = now.Add(idempotencyTTL)
+	}
+}
+
+// forget drops a claim so the request can be retried for real.
+func (c *IdempotencyCache) forget(key idempotencyKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// prune drops stored responses whose TTL has passed.
+func (c *IdempotencyCache) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.done && !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// withIdempotency runs next once per Idempotency-Key and replays its
+// response for repeats. Requests without the header, or without a user,
+// go straight to next. Server errors are not stored, so those can be
+// retried.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientKey := r.Header.Get(idempotencyHeader)
+		userID, ok := userIDFromContext(r.Context())
+		if clientKey == "" || !ok {
+			next(w, r)
+			return
+		}
+		if len(clientKey) > maxIdempotencyKeyLength {
+			writeError(w, invalidRequest("Idempotency-Key is too long"))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		if err != nil {
+			writeError(w, ErrInvalidBody)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := idempotencyKey{userID: userID, method: r.Method, path: r.URL.Path, key: clientKey}
+		fingerprint := sha256.Sum256(append([]byte(r.URL.RawQuery+"\n"), body...))
+
+		stored, err := idempotencyCache.begin(key, fingerprint, time.Now())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if stored != nil {
+			for name, values := range stored.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(idempotencyReplayHeader, "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		kept := false
+		defer func() {
+			// A panicking handler must not leave the key claimed forever
+			if !kept {
+				idempotencyCache.forget(key)
+			}
+		}()
+		next(rec, r)
+		if rec.status < http.StatusInternalServerError {
+			idempotencyCache.finish(key, rec.status, w.Header().Clone(), rec.body.Bytes(), time.Now())
+			kept = true
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
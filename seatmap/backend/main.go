@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -28,17 +33,32 @@ type Seat struct {
 	ID        int64      `json:"id"`
 	Row       int        `json:"row"`
 	Number    int        `json:"number"`
+	Section   string     `json:"section,omitempty"`
+	X         float64    `json:"x"` // position on the venue drawing
+	Y         float64    `json:"y"`
 	Status    SeatStatus `json:"status"`
 	EventID   int64      `json:"event_id"`
 	UpdatedAt time.Time  `json:"updated_at"`
+	SeatAttributes
+	PriceTierID int64 `json:"price_tier_id,omitempty"`
+	PriceCents  int64 `json:"price_cents"` // current price of the seat's tier
+}
+
+// SeatAttributes are the physical properties of a seat shown to buyers.
+type SeatAttributes struct {
+	Accessible     bool `json:"accessible,omitempty"` // wheelchair space
+	ObstructedView bool `json:"obstructed_view,omitempty"`
+	Aisle          bool `json:"aisle,omitempty"`
 }
 
 // Event represents a show or performance for which seats can be booked.
 type Event struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Venue     string    `json:"venue"`
-	StartTime time.Time `json:"start_time"`
+	ID        int64         `json:"id"`
+	Name      string        `json:"name"`
+	Venue     string        `json:"venue"`
+	VenueID   int64         `json:"venue_id,omitempty"` // set when seats come from a Venue layout
+	StartTime time.Time     `json:"start_time"`
+	Settings  EventSettings `json:"settings"`
 }
 
 // Reservation tracks a user's hold on a specific seat.
@@ -49,240 +69,275 @@ type Reservation struct {
 	ExpiresAt time.Time `json:"expires_at"`
 	CreatedAt time.Time `json:"created_at"`
 	Status    string    `json:"status"` // "active", "completed", "cancelled", "expired", etc.
+	GroupID   int64     `json:"group_id,omitempty"`
+	// PriceCents is the seat price recorded when the reservation was booked,
+	// so later tier price changes do not rewrite past sales.
+	PriceCents int64 `json:"price_cents,omitempty"`
+}
+
+// ReservationGroup holds several seats of one event for one user under a
+// single expiry. Its seats are reserved and booked all together or not at all.
+type ReservationGroup struct {
+	ID        int64     `json:"id"`
+	EventID   int64     `json:"event_id"`
+	UserID    int64     `json:"user_id"`
+	SeatIDs   []int64   `json:"seat_ids"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"` // same lifecycle as Reservation
+	// TotalCents is the sum of the booked seat prices, set once booked.
+	TotalCents int64 `json:"total_cents,omitempty"`
 }
 
+// maxSeatsPerGroup caps how many seats one multi-seat reservation may hold.
+const maxSeatsPerGroup = 10
+
 // ----------------------------------------------------------------------
-// 2. GLOBAL MOCK STORAGE & ERRORS
+// 2. ERRORS
 // ----------------------------------------------------------------------
 
+// Errors. Codes are part of the API: clients branch on them, so never
+// change an existing one.
 var (
-	mu                 sync.Mutex
-	mockEvents               = make(map[int64]*Event)
-	mockSeats                = make(map[int64]*Seat)
-	mockReservations         = make(map[int64]*Reservation)
-	eventIDCounter     int64 = 1
-	seatIDCounter      int64 = 1
-	reservationCounter int64 = 1
+	ErrSeatNotFound        = newError(http.StatusNotFound, "SEAT_NOT_FOUND", "seat not found")
+	ErrSeatNotAvailable    = newError(http.StatusConflict, "SEAT_NOT_AVAILABLE", "seat not available")
+	ErrSeatNotReserved     = newError(http.StatusConflict, "SEAT_NOT_RESERVED", "seat not reserved")
+	ErrReservationNotFound = newError(http.StatusNotFound, "RESERVATION_NOT_FOUND", "reservation not found")
+	ErrReservationExpired  = newError(http.StatusGone, "RESERVATION_EXPIRED", "reservation expired")
+	ErrReservationGrouped  = newError(http.StatusConflict, "RESERVATION_GROUPED", "seat is held by a reservation group; book the group instead")
+	ErrHoldLimitReached    = newError(http.StatusConflict, "HOLD_LIMIT_REACHED", "reservation is already held for the maximum time")
+	ErrNoSeatsRequested    = newError(http.StatusBadRequest, "NO_SEATS_REQUESTED", "no seats requested")
+	ErrTooManySeats        = newError(http.StatusBadRequest, "TOO_MANY_SEATS", fmt.Sprintf("at most %d seats can be reserved together", maxSeatsPerGroup))
+	ErrDuplicateSeat       = newError(http.StatusBadRequest, "DUPLICATE_SEAT", "seat requested more than once")
+	ErrSeatsSpanEvents     = newError(http.StatusBadRequest, "SEATS_SPAN_EVENTS", "all seats must belong to the same event")
+	ErrNoAdjacentSeats     = newError(http.StatusConflict, "NO_ADJACENT_SEATS", "no block of adjacent seats is available")
+	ErrTooManyHeldSeats    = newError(http.StatusConflict, "HELD_SEAT_LIMIT_REACHED", "you already hold the maximum number of seats for this event")
+	ErrTicketLimitReached  = newError(http.StatusConflict, "TICKET_LIMIT_REACHED", "you have reached the ticket limit for this event")
+	ErrEventNotFound       = newError(http.StatusNotFound, "EVENT_NOT_FOUND", "event not found")
+	ErrEventHasSales       = newError(http.StatusConflict, "EVENT_HAS_SALES", "event has reserved or booked seats")
+	ErrVenueNotFound       = newError(http.StatusNotFound, "VENUE_NOT_FOUND", "venue not found")
+	ErrPriceTierNotFound   = newError(http.StatusNotFound, "PRICE_TIER_NOT_FOUND", "price tier not found")
+	ErrSectionNotFound     = newError(http.StatusNotFound, "SECTION_NOT_FOUND", "section not found")
+	ErrIdempotencyKeyBusy  = newError(http.StatusConflict, "IDEMPOTENCY_KEY_IN_USE", "a request with this Idempotency-Key is still in progress")
+	ErrIdempotencyKeyReuse = newError(http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used for a different request")
+	ErrInvalidBody         = newError(http.StatusBadRequest, "INVALID_REQUEST", "invalid request body")
+	ErrAdminRequired       = newError(http.StatusForbidden, "FORBIDDEN", "admin access required")
+	ErrRouteNotFound       = newError(http.StatusNotFound, "NOT_FOUND", "no such endpoint")
+	ErrInternal            = newError(http.StatusInternalServerError, "INTERNAL_ERROR", "internal server error")
 )
 
-// Errors
-var (
-	ErrSeatNotFound        = &SeatMapError{"seat not found"}
-	ErrSeatNotAvailable    = &SeatMapError{"seat not available"}
-	ErrSeatNotReserved     = &SeatMapError{"seat not reserved"}
-	ErrReservationNotFound = &SeatMapError{"reservation not found"}
-	ErrReservationExpired  = &SeatMapError{"reservation expired"}
-)
-
-// SeatMapError is a simple custom error type.
+// SeatMapError is an error the API reports to clients: a stable code to
+// branch on, a message for people and the HTTP status that goes with it.
 type SeatMapError struct {
+	Status  int
+	Code    string
 	Message string
+	Details map[string]any
+}
+
+func newError(status int, code, message string) *SeatMapError {
+	return &SeatMapError{Status: status, Code: code, Message: message}
+}
+
+// invalidRequest reports a request that fails validation.
+func invalidRequest(message string) *SeatMapError {
+	return newError(http.StatusBadRequest, "INVALID_REQUEST", message)
 }
 
 func (e *SeatMapError) Error() string {
 	return e.Message
 }
 
-// ----------------------------------------------------------------------
-// 3. INIT() -> SEED DUMMY DATA
-// ----------------------------------------------------------------------
+// errorResponse is the body of every error response:
+// {"error": {"code": "SEAT_NOT_AVAILABLE", "message": "seat not available"}}
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+func (e *SeatMapError) body() errorBody {
+	return errorBody{Code: e.Code, Message: e.Message, Details: e.Details}
+}
 
-func init() {
-	// Create a sample event
-	e := &Event{
-		ID:        eventIDCounter,
-		Name:      "Rock Concert 2025",
-		Venue:     "Mega Stadium",
-		StartTime: time.Now().Add(24 * time.Hour), // tomorrow
-	}
-	mockEvents[e.ID] = e
-	eventIDCounter++
-
-	// Create 5 seats for the above event
-	for i := 1; i <= 5; i++ {
-		s := &Seat{
-			ID:      seatIDCounter,
-			Row:     1,
-			Number:  i,
-			Status:  StatusAvailable,
-			EventID: e.ID,
+// apiError converts any error into what the client is told. Errors that
+// are not meant for clients are logged and reported as INTERNAL_ERROR.
+func apiError(err error) *SeatMapError {
+	var e *SeatMapError
+	var orphan *OrphanSeatError
+	switch {
+	case errors.As(err, &orphan):
+		return &SeatMapError{
+			Status:  http.StatusConflict,
+			Code:    "SINGLE_SEAT_GAP",
+			Message: orphan.Error(),
+			Details: map[string]any{"seat": orphan.Seat},
 		}
-		mockSeats[s.ID] = s
-		seatIDCounter++
+	case errors.As(err, &e):
+		return e
+	default:
+		log.Printf("internal error: %v", err)
+		return ErrInternal
 	}
 }
 
+// writeError sends err as a JSON error response.
+func writeError(w http.ResponseWriter, err error) {
+	e := apiError(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(errorResponse{Error: e.body()})
+}
+
+// routeNotFound is http.NotFound with a JSON body.
+func routeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, ErrRouteNotFound)
+}
+
 // ----------------------------------------------------------------------
-// 4. SSE MANAGER
+// 3. SSE MANAGER
 // ----------------------------------------------------------------------
 
+// SSEMessage is one server-sent event. ID and Event are optional.
+type SSEMessage struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// writeTo writes the message in the text/event-stream format.
+func (m SSEMessage) writeTo(w io.Writer) {
+	if m.ID != "" {
+		fmt.Fprintf(w, "id: %s\n", m.ID)
+	}
+	if m.Event != "" {
+		fmt.Fprintf(w, "event: %s\n", m.Event)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", m.Data)
+}
+
+// sseBufferSize is how many messages a subscriber may fall behind. Deltas
+// are small but arrive one per changed seat, so a bulk change needs room.
+const sseBufferSize = 256
+
+// SSESubscription is one client's feed of messages for an event.
+type SSESubscription struct {
+	C <-chan SSEMessage
+	// Lagged is signalled when a message had to be dropped because C was
+	// full. The client's view is stale from then on and must be resynced.
+	Lagged <-chan struct{}
+
+	ch     chan SSEMessage
+	lagged chan struct{}
+}
+
 // SSEManager manages SSE subscribers for each event.
 type SSEManager struct {
 	mu          sync.Mutex
-	subscribers map[int64][]chan string // eventID -> list of subscriber channels
+	subscribers map[int64][]*SSESubscription // eventID -> list of subscriptions
 }
 
 func NewSSEManager() *SSEManager {
 	return &SSEManager{
-		subscribers: make(map[int64][]chan string),
+		subscribers: make(map[int64][]*SSESubscription),
 	}
 }
 
-// Subscribe returns a channel on which the client will receive seatmap updates for a specific event.
-func (m *SSEManager) Subscribe(eventID int64) <-chan string {
-	ch := make(chan string, 1) // buffered channel to avoid blocking
+// Subscribe returns a subscription on which the client will receive seatmap updates for a specific event.
+func (m *SSEManager) Subscribe(eventID int64) *SSESubscription {
+	sub := &SSESubscription{
+		ch:     make(chan SSEMessage, sseBufferSize), // buffered channel to avoid blocking
+		lagged: make(chan struct{}, 1),
+	}
+	sub.C, sub.Lagged = sub.ch, sub.lagged
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.subscribers[eventID] = append(m.subscribers[eventID], ch)
-	return ch
+	m.subscribers[eventID] = append(m.subscribers[eventID], sub)
+	return sub
 }
 
-// Unsubscribe removes a channel from the SSEManager's subscriber list for the given event.
-func (m *SSEManager) Unsubscribe(eventID int64, ch <-chan string) {
+// Unsubscribe removes a subscription from the SSEManager's subscriber list for the given event.
+func (m *SSEManager) Unsubscribe(eventID int64, sub *SSESubscription) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	subs := m.subscribers[eventID]
 	for i, subscriber := range subs {
-		if subscriber == ch {
+		if subscriber == sub {
 			// Remove it from the slice
 			subs = append(subs[:i], subs[i+1:]...)
 			break
 		}
 	}
+	if len(subs) == 0 {
+		delete(m.subscribers, eventID)
+		return
+	}
 	m.subscribers[eventID] = subs
 }
 
 // Broadcast sends a message to all subscribers of the given event.
-func (m *SSEManager) Broadcast(eventID int64, message string) {
+func (m *SSEManager) Broadcast(eventID int64, message SSEMessage) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if subs, ok := m.subscribers[eventID]; ok {
-		for _, ch := range subs {
+	for _, sub := range m.subscribers[eventID] {
+		select {
+		case sub.ch <- message:
+		default:
+			// The subscriber is too far behind; drop the message and
+			// tell its handler to resync instead of blocking everyone.
 			select {
-			case ch <- message:
+			case sub.lagged <- struct{}{}:
 			default:
-				// If a subscriber's channel is blocked, skip it
 			}
 		}
 	}
 }
 
 // ----------------------------------------------------------------------
-// 5. IN-MEMORY SEAT OPERATIONS
+// 4. HTTP HANDLERS
 // ----------------------------------------------------------------------
 
-// GetAllSeatsForEvent returns all seats for a given event
-func GetAllSeatsForEvent(eventID int64) []*Seat {
-	mu.Lock()
-	defer mu.Unlock()
-
-	var seats []*Seat
-	for _, seat := range mockSeats {
-		if seat.EventID == eventID {
-			copySeat := *seat
-			seats = append(seats, &copySeat)
-		}
-	}
-	return seats
-}
-
-// ReserveSeat attempts to reserve a seat if it is available
-func ReserveSeat(seatID, userID int64, duration time.Duration) error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	seat, found := mockSeats[seatID]
-	if !found {
-		return ErrSeatNotFound
-	}
-	if seat.Status != StatusAvailable {
-		return ErrSeatNotAvailable
-	}
-
-	// Create a new reservation
-	r := &Reservation{
-		ID:        reservationCounter,
-		SeatID:    seatID,
-		UserID:    userID,
-		ExpiresAt: time.Now().Add(duration),
-		CreatedAt: time.Now(),
-		Status:    "active",
-	}
-	mockReservations[r.ID] = r
-	reservationCounter++
-
-	// Update seat status to reserved
-	seat.Status = StatusReserved
-	seat.UpdatedAt = time.Now()
-	return nil
-}
-
-// BookSeat finalizes the purchase if the seat is still reserved by that user
-func BookSeat(seatID, userID int64) error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	seat, found := mockSeats[seatID]
-	if !found {
-		return ErrSeatNotFound
-	}
-	if seat.Status != StatusReserved {
-		return ErrSeatNotReserved
-	}
-
-	// Find the active reservation for this seat/user
-	var res *Reservation
-	for _, r := range mockReservations {
-		if r.SeatID == seatID && r.UserID == userID && r.Status == "active" {
-			res = r
-			break
-		}
-	}
-	if res == nil {
-		return ErrReservationNotFound
-	}
-	if time.Now().After(res.ExpiresAt) {
-		seat.Status = StatusAvailable
-		seat.UpdatedAt = time.Now()
-		res.Status = "expired"
-		return ErrReservationExpired
-	}
-
-	// Mark seat as booked
-	seat.Status = StatusBooked
-	seat.UpdatedAt = time.Now()
+// We'll use a global SSE manager
+var sseManager = NewSSEManager()
 
-	// Mark reservation as completed
-	res.Status = "completed"
-	return nil
-}
+// seatFeed versions seat changes and replays them to reconnecting clients.
+var seatFeed = NewSeatFeed(sseManager, seatChangeLogSize)
 
-// ----------------------------------------------------------------------
-// 6. HTTP HANDLERS
-// ----------------------------------------------------------------------
+// seatChangeLogSize is how many changes per event a reconnecting client can
+// catch up on before it gets a full snapshot instead.
+const seatChangeLogSize = 1000
 
-// We'll use a global SSE manager
-var sseManager = NewSSEManager()
+// store holds all seat state; main picks the implementation.
+var store SeatStore
 
 // getSeatsByEventHandler -> GET /events/{eventID}/seats
 func getSeatsByEventHandler(w http.ResponseWriter, r *http.Request) {
 	parts := splitPath(r.URL.Path)
 	// Expect: ["events", "{eventID}", "seats"]
 	if len(parts) < 3 {
-		http.Error(w, "invalid path; expected /events/{id}/seats", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid path; expected /events/{id}/seats"))
 		return
 	}
 	eventIDStr := parts[1]
 	eventID, err := strconv.ParseInt(eventIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid event ID", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid event ID"))
 		return
 	}
 
-	seats := GetAllSeatsForEvent(eventID)
+	seats, err := store.GetAllSeatsForEvent(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(seats)
 }
@@ -292,38 +347,32 @@ func reserveSeatHandler(w http.ResponseWriter, r *http.Request) {
 	parts := splitPath(r.URL.Path)
 	// Expect: ["seats", "{seatID}", "reserve"]
 	if len(parts) < 3 {
-		http.Error(w, "invalid path; expected /seats/{id}/reserve", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid path; expected /seats/{id}/reserve"))
 		return
 	}
 
 	seatIDStr := parts[1]
 	seatID, err := strconv.ParseInt(seatIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid seat ID", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid seat ID"))
 		return
 	}
 
-	// For demo, userID=1
-	userID := int64(1)
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
 
 	// Duration from query param or 300s default
-	durationStr := r.URL.Query().Get("duration")
-	if durationStr == "" {
-		durationStr = "300"
-	}
-	durationSec, _ := strconv.Atoi(durationStr)
-	duration := time.Duration(durationSec) * time.Second
+	durationSec, _ := strconv.Atoi(r.URL.Query().Get("duration"))
 
-	if err := ReserveSeat(seatID, userID, duration); err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+	if _, err := store.ReserveSeat(seatID, userID, holdDuration(durationSec)); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	// After reserving seat, broadcast updated seatmap for that event
-	seat := getSeatByID(seatID)
-	if seat != nil {
-		broadcastSeatMap(seat.EventID)
-	}
+	// After reserving seat, push its new state to the event's subscribers
+	publishSeat(seatID)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Seat reserved successfully."))
@@ -334,113 +383,384 @@ func bookSeatHandler(w http.ResponseWriter, r *http.Request) {
 	parts := splitPath(r.URL.Path)
 	// Expect: ["seats", "{seatID}", "book"]
 	if len(parts) < 3 {
-		http.Error(w, "invalid path; expected /seats/{id}/book", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid path; expected /seats/{id}/book"))
 		return
 	}
 
 	seatIDStr := parts[1]
 	seatID, err := strconv.ParseInt(seatIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid seat ID", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid seat ID"))
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
 		return
 	}
 
-	// For demo, userID=1
-	userID := int64(1)
+	res, err := store.BookSeat(seatID, userID)
+	if err != nil {
+		if err == ErrReservationExpired {
+			// The expired hold was released; show the seat again
+			publishSeat(seatID)
+		}
+		writeError(w, err)
+		return
+	}
+
+	// After booking, push the seat's new state
+	publishSeat(seatID)
+
+	// Return the completed reservation with the price that was charged
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// releaseSeatHandler -> POST /seats/{seatID}/release
+// Gives up the caller's hold so others can buy the seat right away.
+func releaseSeatHandler(w http.ResponseWriter, r *http.Request) {
+	seatID, ok := parseIDSegment(w, r, 1, "seat")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
 
-	if err := BookSeat(seatID, userID); err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+	res, err := store.ReleaseSeat(seatID, userID)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+	publishSeat(seatID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
 
-	// After booking, broadcast updated seatmap
-	seat := getSeatByID(seatID)
-	if seat != nil {
-		broadcastSeatMap(seat.EventID)
+// extendSeatHandler -> POST /seats/{seatID}/extend?duration=300
+// Moves the expiry of the caller's hold to duration seconds from now, up to
+// maxHoldDuration after the seat was first reserved.
+func extendSeatHandler(w http.ResponseWriter, r *http.Request) {
+	seatID, ok := parseIDSegment(w, r, 1, "seat")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
 	}
+	durationSec, _ := strconv.Atoi(r.URL.Query().Get("duration"))
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Seat booked successfully."))
+	res, err := store.ExtendSeat(seatID, userID, holdDuration(durationSec), maxHoldDuration)
+	if err != nil {
+		if err == ErrReservationExpired {
+			// The expired hold was released; show the seat again
+			publishSeat(seatID)
+		}
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// reserveSeatsRequest is the body of POST /reservation-groups
+type reserveSeatsRequest struct {
+	SeatIDs  []int64 `json:"seat_ids"`
+	Duration int     `json:"duration"` // seconds, defaults to 300 and capped at -max-hold
+}
+
+// reserveSeatsHandler -> POST /reservation-groups
+// Reserves all requested seats under one group with a single expiry, or none.
+func reserveSeatsHandler(w http.ResponseWriter, r *http.Request) {
+	var req reserveSeatsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	if err := validateSeatIDs(req.SeatIDs); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := store.ReserveSeats(req.SeatIDs, userID, holdDuration(req.Duration))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	seatFeed.Publish(group.EventID, group.SeatIDs...)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// bookGroupHandler -> POST /reservation-groups/{groupID}/book
+func bookGroupHandler(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path)
+	// Expect: ["reservation-groups", "{groupID}", "book"]
+	if len(parts) < 3 {
+		writeError(w, invalidRequest("invalid path; expected /reservation-groups/{id}/book"))
+		return
+	}
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		writeError(w, invalidRequest("invalid reservation group ID"))
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := store.BookGroup(groupID, userID)
+	if err != nil {
+		if group != nil {
+			// The expired group's seats were released; show them again
+			seatFeed.Publish(group.EventID, group.SeatIDs...)
+		}
+		writeError(w, err)
+		return
+	}
+
+	seatFeed.Publish(group.EventID, group.SeatIDs...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// releaseGroupHandler -> POST /reservation-groups/{groupID}/release
+func releaseGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, ok := parseIDSegment(w, r, 1, "reservation group")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := store.ReleaseGroup(groupID, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	seatFeed.Publish(group.EventID, group.SeatIDs...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
 }
 
+// extendGroupHandler -> POST /reservation-groups/{groupID}/extend?duration=300
+func extendGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, ok := parseIDSegment(w, r, 1, "reservation group")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	durationSec, _ := strconv.Atoi(r.URL.Query().Get("duration"))
+
+	group, err := store.ExtendGroup(groupID, userID, holdDuration(durationSec), maxHoldDuration)
+	if err != nil {
+		if group != nil {
+			// The expired group's seats were released; show them again
+			seatFeed.Publish(group.EventID, group.SeatIDs...)
+		}
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// sseHeartbeat is how often an idle stream gets a comment line, so proxies
+// keep the connection open and dead clients are noticed. Set by main.
+var sseHeartbeat = 15 * time.Second
+
+// A client that falls behind more than sseMaxResyncs times within
+// sseResyncWindow is disconnected instead of being resynced again.
+const (
+	sseMaxResyncs   = 3
+	sseResyncWindow = time.Minute
+	// sseRetryAfterDisconnect tells a disconnected slow client's browser how
+	// long to wait before reconnecting.
+	sseRetryAfterDisconnect = 10 * time.Second
+)
+
 // sseEventStreamHandler -> GET /events/{eventID}/seats/stream
-// This endpoint keeps the connection open and pushes event updates.
+// This endpoint keeps the connection open and pushes event updates. The
+// first message is a "snapshot" of all seats; after that only "seat" events
+// for changed seats follow. A client reconnecting with Last-Event-ID gets
+// the changes it missed instead of a new snapshot when they are still known.
+//
+// A client that cannot keep up gets a "resync" event followed by a fresh
+// snapshot; one that keeps falling behind gets a final "resync" and is
+// disconnected, and reconnects later from a snapshot.
 func sseEventStreamHandler(w http.ResponseWriter, r *http.Request) {
 	parts := splitPath(r.URL.Path)
 	// Expect: ["events", "{eventID}", "seats", "stream"]
 	if len(parts) < 4 {
-		http.Error(w, "invalid path; expected /events/{id}/seats/stream", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid path; expected /events/{id}/seats/stream"))
 		return
 	}
 	eventIDStr := parts[1]
 	eventID, err := strconv.ParseInt(eventIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid event ID", http.StatusBadRequest)
+		writeError(w, invalidRequest("invalid event ID"))
 		return
 	}
 
-	// Set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	// Keep the connection open
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		writeError(w, newError(http.StatusInternalServerError, "STREAMING_UNSUPPORTED", "streaming unsupported"))
 		return
 	}
 
-	// Subscribe this client to SSE for the given event
-	ch := sseManager.Subscribe(eventID)
+	// Subscribe this client and get the snapshot or missed changes to send first
+	lastEventID := r.Header.Get("Last-Event-ID")
+	sub, initial, err := seatFeed.Subscribe(eventID, lastEventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	defer func() {
 		// On exit, unsubscribe
-		sseManager.Unsubscribe(eventID, ch)
+		sseManager.Unsubscribe(eventID, sub)
 	}()
 
-	// Optionally, send an initial seatmap
-	initialMap := getSeatMapJSON(eventID)
-	if len(initialMap) > 0 {
-		fmt.Fprintf(w, "data: %s\n\n", initialMap)
-		flusher.Flush()
+	// Set headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for _, msg := range initial {
+		msg.writeTo(w)
 	}
+	flusher.Flush()
 
-	// Listen for seatmap updates in a loop
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	var resyncs resyncTracker
+
+	// Listen for seat changes in a loop
 	ctx := r.Context()
 	for {
 		select {
 		case <-ctx.Done():
 			// Client disconnected or request canceled
 			return
-		case msg := <-ch:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
+		case msg := <-sub.C:
+			msg.writeTo(w)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-sub.Lagged:
+			if resyncs.tooSlow(time.Now()) {
+				log.Printf("disconnecting slow SSE client of event %d", eventID)
+				writeResync(w, "client too slow; reconnect later", sseRetryAfterDisconnect)
+				flusher.Flush()
+				return
+			}
+
+			// Start over on a fresh subscription that begins with a snapshot
+			sseManager.Unsubscribe(eventID, sub)
+			sub, initial, err = seatFeed.Subscribe(eventID, "")
+			if err != nil {
+				writeResync(w, "resync failed; reconnect", 0)
+				flusher.Flush()
+				return
+			}
+			writeResync(w, "client fell behind", 0)
+			for _, msg := range initial {
+				msg.writeTo(w)
+			}
 			flusher.Flush()
 		}
 	}
 }
 
+// resyncTracker remembers when a stream client recently fell behind.
+type resyncTracker struct {
+	times []time.Time
+}
+
+// tooSlow records a resync at now and reports whether the client fell
+// behind more than sseMaxResyncs times within sseResyncWindow.
+func (t *resyncTracker) tooSlow(now time.Time) bool {
+	t.times = append(t.times, now)
+	for now.Sub(t.times[0]) > sseResyncWindow {
+		t.times = t.times[1:]
+	}
+	return len(t.times) > sseMaxResyncs
+}
+
+// writeResync sends a "resync" event telling the client to discard its seat
+// map. The empty id clears the browser's Last-Event-ID, so if the stream is
+// closed afterwards the reconnect starts from a snapshot; retry, if set,
+// makes the browser wait that long before reconnecting.
+func writeResync(w io.Writer, reason string, retry time.Duration) {
+	data, _ := json.Marshal(map[string]string{"reason": reason})
+	fmt.Fprint(w, "id\n")
+	if retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n", retry.Milliseconds())
+	}
+	fmt.Fprintf(w, "event: resync\ndata: %s\n\n", data)
+}
+
 // ----------------------------------------------------------------------
-// 7. UTILITIES
+// 5. UTILITIES
 // ----------------------------------------------------------------------
 
-func getSeatMapJSON(eventID int64) string {
-	seats := GetAllSeatsForEvent(eventID)
-	data, _ := json.Marshal(seats)
-	return string(data)
+// maxHoldDuration caps how long a hold can last in total, counting every
+// extension, so nobody can keep a seat off the market indefinitely. Set by main.
+var maxHoldDuration = 15 * time.Minute
+
+// holdDuration turns a requested hold length in seconds into a duration,
+// defaulting to 300s and capped at maxHoldDuration.
+func holdDuration(seconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = 300
+	}
+	d := time.Duration(seconds) * time.Second
+	if d > maxHoldDuration {
+		d = maxHoldDuration
+	}
+	return d
 }
 
+// publishSeat pushes the current state of one seat to its event's subscribers.
+func publishSeat(seatID int64) {
+	if seat := getSeatByID(seatID); seat != nil {
+		seatFeed.PublishSeats(seat.EventID, []*Seat{seat})
+	}
+}
+
+// broadcastSeatMap compares every seat of an event with what subscribers
+// last saw and pushes the differences. Use it after bulk changes.
 func broadcastSeatMap(eventID int64) {
-	// Build JSON of seats for that event
-	seatJSON := getSeatMapJSON(eventID)
-	// Broadcast via SSE
-	sseManager.Broadcast(eventID, seatJSON)
+	seatFeed.Refresh(eventID)
 }
 
-// getSeatByID is a simple helper to fetch a seat from the map by ID (locked).
+// getSeatByID is a simple helper to fetch a seat from the store by ID.
 func getSeatByID(seatID int64) *Seat {
-	mu.Lock()
-	defer mu.Unlock()
-	seat, found := mockSeats[seatID]
-	if !found {
+	seat, err := store.GetSeat(seatID)
+	if err != nil {
 		return nil
 	}
 	return seat
@@ -472,14 +792,14 @@ func splitOnSlash(s string) []string {
 }
 
 // ----------------------------------------------------------------------
-// 8. CORS MIDDLEWARE
+// 6. CORS MIDDLEWARE
 // ----------------------------------------------------------------------
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -490,51 +810,252 @@ func corsMiddleware(next http.Handler) http.Handler {
 }
 
 // ----------------------------------------------------------------------
-// 9. MAIN
+// 7. MAIN
 // ----------------------------------------------------------------------
 
 func main() {
+	storeKind := flag.String("store", "memory", "seat store backend: memory or postgres")
+	dsn := flag.String("dsn", "user=postgres password=123456 dbname=postgres sslmode=disable", "postgres connection string")
+	sweepInterval := flag.Duration("sweep-interval", 10*time.Second, "how often expired reservations are released")
+	authSecret := flag.String("auth-secret", os.Getenv("SEATMAP_AUTH_SECRET"), "HMAC secret used to sign bearer tokens (or SEATMAP_AUTH_SECRET)")
+	issueTokenFor := flag.Int64("issue-token", 0, "print a bearer token for this user ID and exit")
+	tokenTTL := flag.Duration("token-ttl", 24*time.Hour, "lifetime of tokens printed by -issue-token")
+	adminList := flag.String("admin-users", "", "comma-separated user IDs allowed to call /admin endpoints")
+	backplaneKind := flag.String("backplane", "local", "how seat updates reach other instances: local (none) or redis")
+	redisAddr := flag.String("redis-addr", "localhost:6379", "redis address for -backplane redis")
+	dataDir := flag.String("data-dir", "", "keep the memory store in this directory (WAL and snapshots) so it survives restarts")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "how often -data-dir gets a snapshot and its WAL is trimmed")
+	flag.DurationVar(&maxHoldDuration, "max-hold", maxHoldDuration, "longest a seat may stay reserved, including extensions")
+	flag.DurationVar(&idempotencyTTL, "idempotency-ttl", idempotencyTTL, "how long responses to requests with an Idempotency-Key are replayed")
+	flag.DurationVar(&sseHeartbeat, "sse-heartbeat", sseHeartbeat, "how often idle seat streams get a heartbeat (SSE comment or WebSocket ping)")
+	flag.Parse()
+
+	admins, err := parseAdminUsers(*adminList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	adminUsers = admins
+
+	if *authSecret == "" {
+		log.Fatal("an auth secret is required; set -auth-secret or SEATMAP_AUTH_SECRET")
+	}
+	secret := []byte(*authSecret)
+
+	if *issueTokenFor > 0 {
+		token, err := issueToken(secret, *issueTokenFor, *tokenTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(token)
+		return
+	}
+
+	switch *storeKind {
+	case "memory":
+		if *dataDir == "" {
+			memStore := NewMemoryStore()
+			memStore.seedDemoData()
+			store = memStore
+			break
+		}
+		memStore, fresh, err := OpenMemoryStore(*dataDir)
+		if err != nil {
+			log.Fatalf("failed to open %s: %v", *dataDir, err)
+		}
+		if fresh {
+			memStore.seedDemoData()
+		}
+		store = memStore
+		go memStore.runSnapshots(context.Background(), *snapshotInterval)
+	case "postgres":
+		if *dataDir != "" {
+			log.Fatal("-data-dir only applies to -store memory; postgres keeps its own data")
+		}
+		pgStore, err := NewPostgresStore(*dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = pgStore
+	default:
+		log.Fatalf("unknown store %q; expected memory or postgres", *storeKind)
+	}
+
+	// The seat log is the record of what happened; make the seats agree
+	fixed, err := store.ReplaySeatLog()
+	if err != nil {
+		log.Fatalf("failed to replay the seat log: %v", err)
+	}
+	for _, seat := range fixed {
+		log.Printf("seat %d of event %d restored to %s from the seat log", seat.ID, seat.EventID, seat.Status)
+	}
+
+	switch *backplaneKind {
+	case "local":
+	case "redis":
+		if *storeKind != "postgres" {
+			log.Fatal("-backplane redis needs -store postgres; instances cannot share memory stores")
+		}
+		backplane, err := NewRedisBackplane(*redisAddr, seatFeed.epoch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		seatFeed.backplane = backplane
+		go backplane.Run(context.Background(), seatFeed)
+	default:
+		log.Fatalf("unknown backplane %q; expected local or redis", *backplaneKind)
+	}
+
+	// Release expired holds in the background
+	go runExpirySweeper(context.Background(), *sweepInterval)
+
 	mux := http.NewServeMux()
 
-	// GET /events/{id}/seats -> List seats
+	// GET /events -> List events
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			listEventsHandler(w, r)
+			return
+		}
+		routeNotFound(w, r)
+	})
+
+	// GET /events/{id}/seats -> List seats, POST /events/{id}/best-available -> hold the best block
 	mux.HandleFunc("/events/", func(w http.ResponseWriter, r *http.Request) {
-		// handle seats or SSE stream
+		// handle event details, seats or SSE stream
 		parts := splitPath(r.URL.Path)
 		if r.Method == http.MethodGet {
+			if len(parts) == 2 {
+				// GET /events/{id}
+				getEventHandler(w, r)
+				return
+			}
 			if len(parts) == 3 && parts[2] == "seats" {
 				// GET /events/{id}/seats
 				getSeatsByEventHandler(w, r)
 				return
 			}
+			if len(parts) == 3 && parts[2] == "price-tiers" {
+				// GET /events/{id}/price-tiers
+				listPriceTiersHandler(w, r)
+				return
+			}
 			if len(parts) == 4 && parts[2] == "seats" && parts[3] == "stream" {
 				// GET /events/{id}/seats/stream
 				sseEventStreamHandler(w, r)
 				return
 			}
+			if len(parts) == 4 && parts[2] == "seats" && parts[3] == "ws" {
+				// GET /events/{id}/seats/ws
+				seatSocketHandler(w, r)
+				return
+			}
 		}
-		http.NotFound(w, r)
+		if r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "best-available" {
+			// POST /events/{id}/best-available
+			bestAvailableHandler(w, r)
+			return
+		}
+		routeNotFound(w, r)
 	})
 
-	// POST /seats/{id}/reserve or /seats/{id}/book
+	// POST /seats/{id}/reserve, /book, /release or /extend
+	// GET  /seats/{id}/history
 	mux.HandleFunc("/seats/", func(w http.ResponseWriter, r *http.Request) {
+		if parts := splitPath(r.URL.Path); r.Method == http.MethodGet && len(parts) == 3 && parts[2] == "history" {
+			seatHistoryHandler(w, r)
+			return
+		}
 		if r.Method == http.MethodPost {
 			parts := splitPath(r.URL.Path)
 			if len(parts) >= 3 {
 				action := parts[2]
 				switch action {
 				case "reserve":
-					reserveSeatHandler(w, r)
+					withIdempotency(reserveSeatHandler)(w, r)
 					return
 				case "book":
-					bookSeatHandler(w, r)
+					withIdempotency(bookSeatHandler)(w, r)
+					return
+				case "release":
+					releaseSeatHandler(w, r)
+					return
+				case "extend":
+					extendSeatHandler(w, r)
 					return
 				}
 			}
 		}
-		http.NotFound(w, r)
+		routeNotFound(w, r)
+	})
+
+	// POST /reservation-groups or /reservation-groups/{id}/book, /release or /extend
+	mux.HandleFunc("/reservation-groups", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			withIdempotency(reserveSeatsHandler)(w, r)
+			return
+		}
+		routeNotFound(w, r)
 	})
+	mux.HandleFunc("/reservation-groups/", func(w http.ResponseWriter, r *http.Request) {
+		parts := splitPath(r.URL.Path)
+		if r.Method == http.MethodPost && len(parts) == 3 {
+			switch parts[2] {
+			case "book":
+				withIdempotency(bookGroupHandler)(w, r)
+				return
+			case "release":
+				releaseGroupHandler(w, r)
+				return
+			case "extend":
+				extendGroupHandler(w, r)
+				return
+			}
+		}
+		routeNotFound(w, r)
+	})
+
+	// GET /me/reservations -> the caller's holds and bookings
+	mux.HandleFunc("/me/reservations", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			myReservationsHandler(w, r)
+			return
+		}
+		routeNotFound(w, r)
+	})
+
+	// Admin: create/update/delete events and generate their seats
+	mux.HandleFunc("/admin/events", adminEventsRouter)
+	mux.HandleFunc("/admin/events/", adminEventsRouter)
+
+	// GET /venues -> List venues, GET /venues/{id} -> export a layout
+	mux.HandleFunc("/venues", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			listVenuesHandler(w, r)
+			return
+		}
+		routeNotFound(w, r)
+	})
+	mux.HandleFunc("/venues/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && len(splitPath(r.URL.Path)) == 2 {
+			exportVenueHandler(w, r)
+			return
+		}
+		routeNotFound(w, r)
+	})
+
+	// Admin: import a venue layout
+	mux.HandleFunc("/admin/venues", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			importVenueHandler(w, r)
+			return
+		}
+		routeNotFound(w, r)
+	})
+
+	// Anything else gets a JSON 404 as well
+	mux.HandleFunc("/", routeNotFound)
 
-	wrappedMux := corsMiddleware(mux)
+	wrappedMux := corsMiddleware(authMiddleware(secret, mux))
 
 	addr := ":8080"
 	log.Printf("Server listening at http://localhost:8080")
@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryStore keeps all seat map state in process memory. Everything is lost
+// on restart, which is fine for demos and tests, unless the store is opened
+// with OpenMemoryStore, which keeps a WAL and snapshots on disk.
+//
+// Seats, reservations, groups and price tiers live in one eventShard per
+// event with its own lock, so sales of different events never wait on each
+// other. s.mu only guards the catalog: the shard of each event, which event
+// every seat belongs to, and the venues. Locks are always taken catalog
+// first, then shard; code holding a shard lock must never take s.mu.
+type MemoryStore struct {
+	mu               sync.RWMutex
+	events           map[int64]*eventShard
+	seatEvents       map[int64]int64 // seat ID -> event ID
+	venues           map[int64]*Venue
+	eventIDCounter   int64
+	seatIDCounter    int64
+	venueIDCounter   int64
+	sectionIDCounter int64
+
+	// groupEvents maps group IDs to event IDs. Groups are created under a
+	// shard lock, where s.mu cannot be taken, so the index has its own.
+	groupEvents sync.Map
+
+	reservationCounter atomic.Int64
+	groupCounter       atomic.Int64
+	priceTierCounter   atomic.Int64
+	seatEventCounter   atomic.Int64
+
+	wal *WAL // nil unless opened with OpenMemoryStore
+}
+
+// eventShard is one event with everything that is sold for it.
+type eventShard struct {
+	mu           sync.Mutex
+	event        *Event
+	deleted      bool // set by DeleteEvent for callers that already found the shard
+	seats        map[int64]*Seat
+	reservations map[int64]*Reservation
+	held         map[int64]*Reservation // seat ID -> its active reservation
+	groups       map[int64]*ReservationGroup
+	priceTiers   map[int64]*PriceTier
+	history      map[int64][]*SeatEvent // seat ID -> its log, oldest first
+	seatEventIDs *atomic.Int64          // the store's seatEventCounter
+
+	wal     *WAL         // the store's WAL, if any
+	changes shardChanges // written to the WAL by unlock
+}
+
+func newEventShard(e *Event, seatEventIDs *atomic.Int64, wal *WAL) *eventShard {
+	return &eventShard{
+		event:        e,
+		seats:        make(map[int64]*Seat),
+		reservations: make(map[int64]*Reservation),
+		held:         make(map[int64]*Reservation),
+		groups:       make(map[int64]*ReservationGroup),
+		priceTiers:   make(map[int64]*PriceTier),
+		history:      make(map[int64][]*SeatEvent),
+		seatEventIDs: seatEventIDs,
+		wal:          wal,
+	}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		events:           make(map[int64]*eventShard),
+		seatEvents:       make(map[int64]int64),
+		venues:           make(map[int64]*Venue),
+		eventIDCounter:   1,
+		seatIDCounter:    1,
+		venueIDCounter:   1,
+		sectionIDCounter: 1,
+	}
+}
+
+// seedDemoData creates the sample event and its 5 seats.
+func (s *MemoryStore) seedDemoData() {
+	// Create a sample event
+	// with 5 seats
+	seats := make([]*Seat, 0, 5)
+	for i := 1; i <= 5; i++ {
+		seats = append(seats, &Seat{Row: 1, Number: i, X: float64(i), Y: 1})
+	}
+	s.CreateEvent(&Event{
+		Name:      "Rock Concert 2025",
+		Venue:     "Mega Stadium",
+		StartTime: time.Now().Add(24 * time.Hour), // tomorrow
+	}, seats)
+}
+
+// lockEvent returns the shard of an event with its lock held, or nil if the
+// event does not exist.
+func (s *MemoryStore) lockEvent(eventID int64) *eventShard {
+	s.mu.RLock()
+	sh := s.events[eventID]
+	s.mu.RUnlock()
+	if sh == nil {
+		return nil
+	}
+	sh.mu.Lock()
+	if sh.deleted {
+		sh.mu.Unlock()
+		return nil
+	}
+	return sh
+}
+
+// lockSeat returns a seat together with its shard, whose lock is held, or
+// nil if the seat does not exist.
+func (s *MemoryStore) lockSeat(seatID int64) (*eventShard, *Seat) {
+	s.mu.RLock()
+	eventID, found := s.seatEvents[seatID]
+	s.mu.RUnlock()
+	if !found {
+		return nil, nil
+	}
+	sh := s.lockEvent(eventID)
+	if sh == nil {
+		return nil, nil
+	}
+	seat, found := sh.seats[seatID]
+	if !found {
+		// The seats of the event were replaced in the meantime
+		sh.mu.Unlock()
+		return nil, nil
+	}
+	return sh, seat
+}
+
+// lockGroup returns a group together with its shard, whose lock is held, or
+// nil if the group does not exist.
+func (s *MemoryStore) lockGroup(groupID int64) (*eventShard, *ReservationGroup) {
+	eventID, found := s.groupEvents.Load(groupID)
+	if !found {
+		return nil, nil
+	}
+	sh := s.lockEvent(eventID.(int64))
+	if sh == nil {
+		return nil, nil
+	}
+	g, found := sh.groups[groupID]
+	if !found {
+		sh.mu.Unlock()
+		return nil, nil
+	}
+	return sh, g
+}
+
+// ListEvents returns all events ordered by start time
+func (s *MemoryStore) ListEvents() ([]*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]*Event, 0, len(s.events))
+	for _, sh := range s.events {
+		sh.mu.Lock()
+		copyEvent := *sh.event
+		sh.mu.Unlock()
+		events = append(events, &copyEvent)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartTime.Before(events[j].StartTime)
+	})
+	return events, nil
+}
+
+// GetEvent returns a copy of a single event
+func (s *MemoryStore) GetEvent(eventID int64) (*Event, error) {
+	sh := s.lockEvent(eventID)
+	if sh == nil {
+		return nil, ErrEventNotFound
+	}
+	defer sh.unlock()
+
+	copyEvent := *sh.event
+	return &copyEvent, nil
+}
+
+// CreateEvent stores a new event with its seats. Nobody can see the event
+// before both are in place, as s.mu is held throughout.
+func (s *MemoryStore) CreateEvent(e *Event, seats []*Seat) (*Event, []*Seat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copyEvent := *e
+	copyEvent.ID = s.eventIDCounter
+	sh := newEventShard(&copyEvent, &s.seatEventCounter, s.wal)
+	s.eventIDCounter++
+	s.logCatalog(&walRecord{Op: "update", EventID: copyEvent.ID, Event: &copyEvent})
+
+	var created []*Seat
+	if len(seats) > 0 {
+		sh.mu.Lock()
+		created = s.addEventSeats(sh, seats)
+		sh.unlock()
+	}
+	s.events[copyEvent.ID] = sh
+
+	result := copyEvent
+	return &result, created, nil
+}
+
+// UpdateEvent overwrites an existing event
+func (s *MemoryStore) UpdateEvent(e *Event) (*Event, error) {
+	sh := s.lockEvent(e.ID)
+	if sh == nil {
+		return nil, ErrEventNotFound
+	}
+	defer sh.unlock()
+
+	existing := sh.event
+	existing.Name = e.Name
+	existing.Venue = e.Venue
+	existing.VenueID = e.VenueID
+	existing.StartTime = e.StartTime
+	existing.Settings = e.Settings
+	sh.markEvent()
+
+	copyEvent := *existing
+	return &copyEvent, nil
+}
+
+// DeleteEvent removes an event with all its seats and reservation history
+func (s *MemoryStore) DeleteEvent(eventID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, found := s.events[eventID]
+	if !found {
+		return ErrEventNotFound
+	}
+	sh.mu.Lock()
+	defer sh.unlock()
+
+	if err := s.removeEventSeats(sh); err != nil {
+		return err
+	}
+	sh.deleted = true
+	delete(s.events, eventID)
+	s.logCatalog(&walRecord{Op: "delete_event", EventID: eventID})
+	return nil
+}
+
+// ReplaceEventSeats drops the current seats of an event and stores new ones
+func (s *MemoryStore) ReplaceEventSeats(eventID int64, seats []*Seat) ([]*Seat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, found := s.events[eventID]
+	if !found {
+		return nil, ErrEventNotFound
+	}
+	sh.mu.Lock()
+	defer sh.unlock()
+
+	if err := s.removeEventSeats(sh); err != nil {
+		return nil, err
+	}
+	return s.addEventSeats(sh, seats), nil
+}
+
+// addEventSeats stores new available seats in an event and returns copies
+// with their IDs. The caller must hold s.mu and sh.mu.
+func (s *MemoryStore) addEventSeats(sh *eventShard, seats []*Seat) []*Seat {
+	eventID := sh.event.ID
+	now := time.Now()
+	created := make([]*Seat, 0, len(seats))
+	for _, seat := range seats {
+		stored := *seat
+		stored.ID = s.seatIDCounter
+		stored.EventID = eventID
+		stored.Status = StatusAvailable
+		stored.UpdatedAt = now
+		sh.seats[stored.ID] = &stored
+		s.seatEvents[stored.ID] = eventID
+		s.seatIDCounter++
+
+		copySeat := stored
+		created = append(created, &copySeat)
+	}
+	s.logCatalog(&walRecord{Op: "replace_seats", EventID: eventID, Seats: created})
+	return created
+}
+
+// seatSnapshot copies a seat and fills in the current price of its tier.
+// The caller must hold sh.mu.
+func (sh *eventShard) seatSnapshot(seat *Seat) *Seat {
+	copySeat := *seat
+	copySeat.PriceCents = sh.priceOf(seat)
+	return &copySeat
+}
+
+// priceOf returns the current price of a seat's tier, 0 if it has none.
+// The caller must hold sh.mu.
+func (sh *eventShard) priceOf(seat *Seat) int64 {
+	if t, found := sh.priceTiers[seat.PriceTierID]; found {
+		return t.PriceCents
+	}
+	return 0
+}
+
+// removeEventSeats deletes the seats, reservations and groups of an event.
+// The caller must hold s.mu and sh.mu.
+func (s *MemoryStore) removeEventSeats(sh *eventShard) error {
+	for _, seat := range sh.seats {
+		if seat.Status != StatusAvailable {
+			return ErrEventHasSales
+		}
+	}
+	for id := range sh.seats {
+		delete(s.seatEvents, id)
+	}
+	for id := range sh.groups {
+		s.groupEvents.Delete(id)
+	}
+	sh.seats = make(map[int64]*Seat)
+	sh.reservations = make(map[int64]*Reservation)
+	sh.held = make(map[int64]*Reservation)
+	sh.groups = make(map[int64]*ReservationGroup)
+	sh.history = make(map[int64][]*SeatEvent)
+	return nil
+}
+
+// ListVenues returns all venues without their sections
+func (s *MemoryStore) ListVenues() ([]*Venue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	venues := make([]*Venue, 0, len(s.venues))
+	for _, v := range s.venues {
+		summary := *v
+		summary.Sections = nil
+		venues = append(venues, &summary)
+	}
+	sort.Slice(venues, func(i, j int) bool { return venues[i].ID < venues[j].ID })
+	return venues, nil
+}
+
+// GetVenue returns a deep copy of a venue layout
+func (s *MemoryStore) GetVenue(venueID int64) (*Venue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, found := s.venues[venueID]
+	if !found {
+		return nil, ErrVenueNotFound
+	}
+	return copyVenue(v), nil
+}
+
+// CreateVenue stores a deep copy of a venue layout
+func (s *MemoryStore) CreateVenue(v *Venue) (*Venue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := copyVenue(v)
+	stored.ID = s.venueIDCounter
+	s.venueIDCounter++
+	for _, sec := range stored.Sections {
+		sec.ID = s.sectionIDCounter
+		s.sectionIDCounter++
+	}
+	s.venues[stored.ID] = stored
+	s.logCatalog(&walRecord{Op: "venue", Venue: stored})
+	return copyVenue(stored), nil
+}
+
+// GetAllSeatsForEvent returns all seats for a given event
+func (s *MemoryStore) GetAllSeatsForEvent(eventID int64) ([]*Seat, error) {
+	sh := s.lockEvent(eventID)
+	if sh == nil {
+		return nil, nil
+	}
+	defer sh.unlock()
+
+	seats := make([]*Seat, 0, len(sh.seats))
+	for _, seat := range sh.seats {
+		seats = append(seats, sh.seatSnapshot(seat))
+	}
+	return seats, nil
+}
+
+// GetSeat returns a copy of a single seat
+func (s *MemoryStore) GetSeat(seatID int64) (*Seat, error) {
+	sh, seat := s.lockSeat(seatID)
+	if sh == nil {
+		return nil, ErrSeatNotFound
+	}
+	defer sh.unlock()
+
+	return sh.seatSnapshot(seat), nil
+}
+
+// ReserveSeat attempts to reserve a seat if it is available
+func (s *MemoryStore) ReserveSeat(seatID, userID int64, duration time.Duration) (*Reservation, error) {
+	sh, seat := s.lockSeat(seatID)
+	if sh == nil {
+		return nil, ErrSeatNotFound
+	}
+	defer sh.unlock()
+
+	if seat.Status != StatusAvailable {
+		return nil, ErrSeatNotAvailable
+	}
+	if err := sh.checkSingleSeatGaps([]*Seat{seat}); err != nil {
+		return nil, err
+	}
+	if err := sh.checkPurchaseLimits(userID, 1); err != nil {
+		return nil, err
+	}
+
+	// Create a new reservation
+	r := &Reservation{
+		ID:        s.reservationCounter.Add(1),
+		SeatID:    seatID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(duration),
+		CreatedAt: time.Now(),
+		Status:    "active",
+	}
+	sh.reservations[r.ID] = r
+	sh.held[seatID] = r
+
+	// Update seat status to reserved
+	seat.Status = StatusReserved
+	seat.UpdatedAt = time.Now()
+	sh.logSeat(r, SeatEventReserved, userID, r.CreatedAt)
+
+	copyRes := *r
+	return &copyRes, nil
+}
+
+// BookSeat finalizes the purchase if the seat is still reserved by that user
+func (s *MemoryStore) BookSeat(seatID, userID int64) (*Reservation, error) {
+	sh, seat := s.lockSeat(seatID)
+	if sh == nil {
+		return nil, ErrSeatNotFound
+	}
+	defer sh.unlock()
+
+	if seat.Status != StatusReserved {
+		return nil, ErrSeatNotReserved
+	}
+
+	// Find the active reservation for this seat/user
+	res := sh.activeReservation(seatID, userID)
+	if res == nil {
+		return nil, ErrReservationNotFound
+	}
+	if res.GroupID != 0 {
+		return nil, ErrReservationGrouped
+	}
+	if time.Now().After(res.ExpiresAt) {
+		sh.endReservation(res, "expired", time.Now())
+		return nil, ErrReservationExpired
+	}
+
+	// Mark seat as booked and the reservation as completed at the current price
+	res.PriceCents = sh.priceOf(seat)
+	sh.endReservation(res, "completed", time.Now())
+
+	copyRes := *res
+	return &copyRes, nil
+}
+
+// checkSingleSeatGaps returns an *OrphanSeatError if the event forbids
+// single-seat gaps and taking the selected seats would leave one. The caller
+// must hold sh.mu.
+func (sh *eventShard) checkSingleSeatGaps(selected []*Seat) error {
+	if !sh.event.Settings.NoSingleSeatGaps {
+		return nil
+	}
+	type rowKey struct {
+		section string
+		row     int
+	}
+	rows := make(map[rowKey]bool, len(selected))
+	for _, seat := range selected {
+		rows[rowKey{seat.Section, seat.Row}] = true
+	}
+	var nearby []*Seat
+	for _, seat := range sh.seats {
+		if rows[rowKey{seat.Section, seat.Row}] {
+			nearby = append(nearby, seat)
+		}
+	}
+	if orphan := findOrphanedSeat(selected, nearby); orphan != nil {
+		return &OrphanSeatError{Seat: sh.seatSnapshot(orphan)}
+	}
+	return nil
+}
+
+// checkPurchaseLimits returns an error if reserving n more seats would take
+// the user past the event's limits. The caller must hold sh.mu.
+func (sh *eventShard) checkPurchaseLimits(userID int64, n int) error {
+	settings := sh.event.Settings
+	if !settings.hasPurchaseLimits() {
+		return nil
+	}
+	now := time.Now()
+	held, bought := 0, 0
+	for _, r := range sh.reservations {
+		if r.UserID != userID {
+			continue
+		}
+		switch {
+		case r.Status == "active" && now.Before(r.ExpiresAt):
+			held++
+		case r.Status == "completed":
+			bought++
+		}
+	}
+	return settings.checkPurchaseLimits(held, bought, n)
+}
+
+// ReleaseSeat cancels the user's active hold on a seat
+func (s *MemoryStore) ReleaseSeat(seatID, userID int64) (*Reservation, error) {
+	sh, seat := s.lockSeat(seatID)
+	if sh == nil {
+		return nil, ErrSeatNotFound
+	}
+	defer sh.unlock()
+
+	if seat.Status != StatusReserved {
+		return nil, ErrSeatNotReserved
+	}
+
+	res := sh.activeReservation(seatID, userID)
+	if res == nil {
+		return nil, ErrReservationNotFound
+	}
+	if res.GroupID != 0 {
+		return nil, ErrReservationGrouped
+	}
+	sh.endReservation(res, "cancelled", time.Now())
+
+	copyRes := *res
+	return &copyRes, nil
+}
+
+// ExtendSeat pushes back the expiry of the user's active hold on a seat
+func (s *MemoryStore) ExtendSeat(seatID, userID int64, duration, maxHold time.Duration) (*Reservation, error) {
+	sh, seat := s.lockSeat(seatID)
+	if sh == nil {
+		return nil, ErrSeatNotFound
+	}
+	defer sh.unlock()
+
+	if seat.Status != StatusReserved {
+		return nil, ErrSeatNotReserved
+	}
+
+	res := sh.activeReservation(seatID, userID)
+	if res == nil {
+		return nil, ErrReservationNotFound
+	}
+	if res.GroupID != 0 {
+		return nil, ErrReservationGrouped
+	}
+	now := time.Now()
+	if now.After(res.ExpiresAt) {
+		sh.endReservation(res, "expired", now)
+		return nil, ErrReservationExpired
+	}
+
+	expiresAt, err := extendedExpiry(res.CreatedAt, res.ExpiresAt, now, duration, maxHold)
+	if err != nil {
+		return nil, err
+	}
+	res.ExpiresAt = expiresAt
+	sh.markReservation(res)
+
+	copyRes := *res
+	return &copyRes, nil
+}
+
+// activeReservation finds the user's active reservation of a seat.
+// The caller must hold sh.mu.
+func (sh *eventShard) activeReservation(seatID, userID int64) *Reservation {
+	if r := sh.held[seatID]; r != nil && r.UserID == userID {
+		return r
+	}
+	return nil
+}
+
+// endReservation gives status to an active reservation. Its seat is booked
+// if the status is "completed" and available again otherwise. The caller
+// must hold sh.mu.
+func (sh *eventShard) endReservation(r *Reservation, status string, now time.Time) {
+	r.Status = status
+	delete(sh.held, r.SeatID)
+
+	seat, found := sh.seats[r.SeatID]
+	if !found {
+		return
+	}
+	if status == "completed" {
+		seat.Status = StatusBooked
+	} else {
+		seat.Status = StatusAvailable
+	}
+	seat.UpdatedAt = now
+
+	t, actor := seatEventFor(r, status)
+	sh.logSeat(r, t, actor, now)
+}
+
+// logSeat appends a transition of a reservation's seat to the seat's log.
+// The caller must hold sh.mu.
+func (sh *eventShard) logSeat(r *Reservation, t SeatEventType, actor int64, now time.Time) {
+	e := &SeatEvent{
+		ID:            sh.seatEventIDs.Add(1),
+		SeatID:        r.SeatID,
+		EventID:       sh.event.ID,
+		Type:          t,
+		UserID:        r.UserID,
+		ActorID:       actor,
+		ReservationID: r.ID,
+		GroupID:       r.GroupID,
+		At:            now,
+	}
+	sh.history[r.SeatID] = append(sh.history[r.SeatID], e)
+	sh.markReservation(r)
+	sh.markSeatEvent(e)
+}
+
+// ReserveSeats reserves all requested seats under one group, or none of them
+func (s *MemoryStore) ReserveSeats(seatIDs []int64, userID int64, duration time.Duration) (*ReservationGroup, error) {
+	if err := validateSeatIDs(seatIDs); err != nil {
+		return nil, err
+	}
+
+	// All seats must share one event, and so one shard
+	s.mu.RLock()
+	eventID, found := s.seatEvents[seatIDs[0]]
+	for _, seatID := range seatIDs[1:] {
+		other, ok := s.seatEvents[seatID]
+		if !ok {
+			found = false
+		} else if found && other != eventID {
+			s.mu.RUnlock()
+			return nil, ErrSeatsSpanEvents
+		}
+	}
+	s.mu.RUnlock()
+	if !found {
+		return nil, ErrSeatNotFound
+	}
+	sh := s.lockEvent(eventID)
+	if sh == nil {
+		return nil, ErrSeatNotFound
+	}
+	defer sh.unlock()
+
+	// Check every seat before touching any of them
+	seats := make([]*Seat, 0, len(seatIDs))
+	for _, seatID := range seatIDs {
+		seat, found := sh.seats[seatID]
+		if !found {
+			return nil, ErrSeatNotFound
+		}
+		if seat.Status != StatusAvailable {
+			return nil, ErrSeatNotAvailable
+		}
+		seats = append(seats, seat)
+	}
+	if err := sh.checkSingleSeatGaps(seats); err != nil {
+		return nil, err
+	}
+	if err := sh.checkPurchaseLimits(userID, len(seats)); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	g := &ReservationGroup{
+		ID:        s.groupCounter.Add(1),
+		EventID:   eventID,
+		UserID:    userID,
+		SeatIDs:   append([]int64(nil), seatIDs...),
+		ExpiresAt: now.Add(duration),
+		CreatedAt: now,
+		Status:    "active",
+	}
+	sh.groups[g.ID] = g
+	s.groupEvents.Store(g.ID, eventID)
+	sh.markGroup(g)
+
+	for _, seat := range seats {
+		r := &Reservation{
+			ID:        s.reservationCounter.Add(1),
+			SeatID:    seat.ID,
+			UserID:    userID,
+			ExpiresAt: g.ExpiresAt,
+			CreatedAt: now,
+			Status:    "active",
+			GroupID:   g.ID,
+		}
+		sh.reservations[r.ID] = r
+		sh.held[seat.ID] = r
+
+		seat.Status = StatusReserved
+		seat.UpdatedAt = now
+		sh.logSeat(r, SeatEventReserved, userID, now)
+	}
+
+	return copyGroup(g), nil
+}
+
+// groupReservations returns the active reservations of a group.
+// The caller must hold sh.mu.
+func (sh *eventShard) groupReservations(g *ReservationGroup) []*Reservation {
+	var active []*Reservation
+	for _, seatID := range g.SeatIDs {
+		if r := sh.held[seatID]; r != nil && r.GroupID == g.ID {
+			active = append(active, r)
+		}
+	}
+	return active
+}
+
+// BookGroup books every seat of an active reservation group owned by the user
+func (s *MemoryStore) BookGroup(groupID, userID int64) (*ReservationGroup, error) {
+	sh, g := s.lockGroup(groupID)
+	if sh == nil {
+		return nil, ErrReservationNotFound
+	}
+	defer sh.unlock()
+
+	if g.UserID != userID || g.Status != "active" {
+		return nil, ErrReservationNotFound
+	}
+
+	now := time.Now()
+	if now.After(g.ExpiresAt) {
+		sh.endGroup(g, "expired", now)
+		return copyGroup(g), ErrReservationExpired
+	}
+	for _, r := range sh.groupReservations(g) {
+		r.PriceCents = sh.priceOf(sh.seats[r.SeatID])
+		g.TotalCents += r.PriceCents
+		sh.endReservation(r, "completed", now)
+	}
+	g.Status = "completed"
+	sh.markGroup(g)
+	return copyGroup(g), nil
+}
+
+// ReleaseGroup cancels an active reservation group owned by the user
+func (s *MemoryStore) ReleaseGroup(groupID, userID int64) (*ReservationGroup, error) {
+	sh, g := s.lockGroup(groupID)
+	if sh == nil {
+		return nil, ErrReservationNotFound
+	}
+	defer sh.unlock()
+
+	if g.UserID != userID || g.Status != "active" {
+		return nil, ErrReservationNotFound
+	}
+	sh.endGroup(g, "cancelled", time.Now())
+	return copyGroup(g), nil
+}
+
+// ExtendGroup pushes back the expiry of every seat of an active group
+func (s *MemoryStore) ExtendGroup(groupID, userID int64, duration, maxHold time.Duration) (*ReservationGroup, error) {
+	sh, g := s.lockGroup(groupID)
+	if sh == nil {
+		return nil, ErrReservationNotFound
+	}
+	defer sh.unlock()
+
+	if g.UserID != userID || g.Status != "active" {
+		return nil, ErrReservationNotFound
+	}
+	now := time.Now()
+	if now.After(g.ExpiresAt) {
+		sh.endGroup(g, "expired", now)
+		return copyGroup(g), ErrReservationExpired
+	}
+
+	expiresAt, err := extendedExpiry(g.CreatedAt, g.ExpiresAt, now, duration, maxHold)
+	if err != nil {
+		return nil, err
+	}
+	g.ExpiresAt = expiresAt
+	sh.markGroup(g)
+	for _, r := range sh.groupReservations(g) {
+		r.ExpiresAt = expiresAt
+		sh.markReservation(r)
+	}
+	return copyGroup(g), nil
+}
+
+// endGroup gives up every active reservation of a group with the given
+// status and frees their seats. The caller must hold sh.mu.
+func (sh *eventShard) endGroup(g *ReservationGroup, status string, now time.Time) {
+	for _, r := range sh.groupReservations(g) {
+		sh.endReservation(r, status, now)
+	}
+	g.Status = status
+	sh.markGroup(g)
+}
+
+// ListPriceTiers returns the price tiers of an event ordered by ID
+func (s *MemoryStore) ListPriceTiers(eventID int64) ([]*PriceTier, error) {
+	sh := s.lockEvent(eventID)
+	if sh == nil {
+		return nil, ErrEventNotFound
+	}
+	defer sh.unlock()
+
+	tiers := make([]*PriceTier, 0, len(sh.priceTiers))
+	for _, t := range sh.priceTiers {
+		copyTier := *t
+		tiers = append(tiers, &copyTier)
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].ID < tiers[j].ID })
+	return tiers, nil
+}
+
+// CreatePriceTier adds a price tier to an event
+func (s *MemoryStore) CreatePriceTier(t *PriceTier) (*PriceTier, error) {
+	sh := s.lockEvent(t.EventID)
+	if sh == nil {
+		return nil, ErrEventNotFound
+	}
+	defer sh.unlock()
+
+	stored := *t
+	stored.ID = s.priceTierCounter.Add(1)
+	sh.priceTiers[stored.ID] = &stored
+	sh.markPriceTier(stored.ID)
+
+	copyTier := stored
+	return &copyTier, nil
+}
+
+// UpdatePriceTier changes an existing tier of the same event
+func (s *MemoryStore) UpdatePriceTier(t *PriceTier) (*PriceTier, error) {
+	sh := s.lockEvent(t.EventID)
+	if sh == nil {
+		return nil, ErrPriceTierNotFound
+	}
+	defer sh.unlock()
+
+	existing, found := sh.priceTiers[t.ID]
+	if !found {
+		return nil, ErrPriceTierNotFound
+	}
+	existing.Name = t.Name
+	existing.PriceCents = t.PriceCents
+	existing.Currency = t.Currency
+	sh.markPriceTier(existing.ID)
+
+	copyTier := *existing
+	return &copyTier, nil
+}
+
+// AssignPriceTiers sets the tier of whole sections, then of single seats
+func (s *MemoryStore) AssignPriceTiers(eventID int64, a PriceAssignment) error {
+	sh := s.lockEvent(eventID)
+	if sh == nil {
+		return ErrEventNotFound
+	}
+	defer sh.unlock()
+
+	// Validate everything first so a bad entry changes nothing
+	checkTier := func(tierID int64) error {
+		if _, found := sh.priceTiers[tierID]; !found {
+			return ErrPriceTierNotFound
+		}
+		return nil
+	}
+	sections := make(map[string]bool)
+	for _, seat := range sh.seats {
+		sections[seat.Section] = true
+	}
+	for section, tierID := range a.Sections {
+		if !sections[section] {
+			return ErrSectionNotFound
+		}
+		if err := checkTier(tierID); err != nil {
+			return err
+		}
+	}
+	for seatID, tierID := range a.Seats {
+		if _, found := sh.seats[seatID]; !found {
+			return ErrSeatNotFound
+		}
+		if err := checkTier(tierID); err != nil {
+			return err
+		}
+	}
+
+	for _, seat := range sh.seats {
+		if tierID, ok := a.Sections[seat.Section]; ok {
+			seat.PriceTierID = tierID
+			sh.markSeat(seat.ID)
+		}
+	}
+	for seatID, tierID := range a.Seats {
+		sh.seats[seatID].PriceTierID = tierID
+		sh.markSeat(seatID)
+	}
+	return nil
+}
+
+// ExpireReservations frees the seats of all active reservations past their
+// expiry. Events are swept one at a time, so sales of the others go on.
+func (s *MemoryStore) ExpireReservations(now time.Time) ([]*Seat, error) {
+	s.mu.RLock()
+	shards := make([]*eventShard, 0, len(s.events))
+	for _, sh := range s.events {
+		shards = append(shards, sh)
+	}
+	s.mu.RUnlock()
+
+	var freed []*Seat
+	for _, sh := range shards {
+		freed = append(freed, sh.expireReservations(now)...)
+	}
+	return freed, nil
+}
+
+// expireReservations is ExpireReservations for one event.
+func (sh *eventShard) expireReservations(now time.Time) []*Seat {
+	sh.mu.Lock()
+	defer sh.unlock()
+
+	var freed []*Seat
+	for _, r := range sh.held {
+		if !now.After(r.ExpiresAt) {
+			continue
+		}
+		sh.endReservation(r, "expired", now)
+		if g, ok := sh.groups[r.GroupID]; ok {
+			g.Status = "expired"
+			sh.markGroup(g)
+		}
+		if seat, found := sh.seats[r.SeatID]; found {
+			freed = append(freed, sh.seatSnapshot(seat))
+		}
+	}
+	return freed
+}
+
+// ListUserReservations returns the user's current holds and bookings
+func (s *MemoryStore) ListUserReservations(userID, eventID int64) ([]*UserReservation, error) {
+	s.mu.RLock()
+	var shards []*eventShard
+	for id, sh := range s.events {
+		if eventID == 0 || id == eventID {
+			shards = append(shards, sh)
+		}
+	}
+	s.mu.RUnlock()
+
+	now := time.Now()
+	var list []*UserReservation
+	for _, sh := range shards {
+		sh.mu.Lock()
+		for _, r := range sh.reservations {
+			current := r.Status == "completed" || (r.Status == "active" && now.Before(r.ExpiresAt))
+			seat, found := sh.seats[r.SeatID]
+			if r.UserID != userID || !current || !found {
+				continue
+			}
+			copyRes := *r
+			list = append(list, &UserReservation{Reservation: &copyRes, Seat: sh.seatSnapshot(seat)})
+		}
+		sh.mu.Unlock()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list, nil
+}
+
+// SeatHistory returns a copy of a seat's log
+func (s *MemoryStore) SeatHistory(seatID int64) ([]*SeatEvent, error) {
+	sh, _ := s.lockSeat(seatID)
+	if sh == nil {
+		return nil, ErrSeatNotFound
+	}
+	defer sh.unlock()
+
+	history := make([]*SeatEvent, 0, len(sh.history[seatID]))
+	for _, e := range sh.history[seatID] {
+		copyEvent := *e
+		history = append(history, &copyEvent)
+	}
+	return history, nil
+}
+
+// ReplaySeatLog sets every seat with a log to the status its last entry
+// leads to. Seats are only ever changed together with their log, so this
+// finds nothing unless state was restored from elsewhere.
+func (s *MemoryStore) ReplaySeatLog() ([]*Seat, error) {
+	s.mu.RLock()
+	shards := make([]*eventShard, 0, len(s.events))
+	for _, sh := range s.events {
+		shards = append(shards, sh)
+	}
+	s.mu.RUnlock()
+
+	var fixed []*Seat
+	for _, sh := range shards {
+		sh.mu.Lock()
+		for seatID, history := range sh.history {
+			seat, found := sh.seats[seatID]
+			if !found || len(history) == 0 {
+				continue
+			}
+			last := history[len(history)-1]
+			if want := seatStatusAfter(last.Type); seat.Status != want {
+				seat.Status = want
+				seat.UpdatedAt = last.At
+				sh.markSeat(seatID)
+				fixed = append(fixed, sh.seatSnapshot(seat))
+			}
+		}
+		sh.unlock()
+	}
+	return fixed, nil
+}
+
+func copyGroup(g *ReservationGroup) *ReservationGroup {
+	copyG := *g
+	copyG.SeatIDs = append([]int64(nil), g.SeatIDs...)
+	return &copyG
+}
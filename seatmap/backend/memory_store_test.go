@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newBenchStore creates events with seatsPerEvent seats each and returns
+// the seat IDs of every event.
+func newBenchStore(b *testing.B, events, seatsPerEvent int) (*MemoryStore, [][]int64) {
+	b.Helper()
+	s := NewMemoryStore()
+	seatIDs := make([][]int64, events)
+	for i := range seatIDs {
+		layout := make([]*Seat, seatsPerEvent)
+		for j := range layout {
+			layout[j] = &Seat{Row: j/20 + 1, Number: j%20 + 1}
+		}
+		_, seats, err := s.CreateEvent(&Event{Name: fmt.Sprintf("Event %d", i), StartTime: time.Now()}, layout)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, seat := range seats {
+			seatIDs[i] = append(seatIDs[i], seat.ID)
+		}
+	}
+	return s, seatIDs
+}
+
+// BenchmarkMemoryStoreReserveParallel reserves and releases seats from
+// many goroutines at once. Each goroutine has its own seat in every event
+// and walks the events in turn, so calls only compete for locks, never for
+// seats. With per-event locks throughput should grow with the number of
+// events; with one store-wide lock it stays flat.
+func BenchmarkMemoryStoreReserveParallel(b *testing.B) {
+	const seatsPerEvent = 200
+	for _, events := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("events=%d", events), func(b *testing.B) {
+			s, seatIDs := newBenchStore(b, events, seatsPerEvent)
+			var workers atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				worker := int(workers.Add(1))
+				if worker >= seatsPerEvent {
+					b.Error("more goroutines than seats per event")
+					return
+				}
+				userID := int64(worker)
+				for i := worker; pb.Next(); i++ {
+					seatID := seatIDs[i%events][worker]
+					if _, err := s.ReserveSeat(seatID, userID, time.Minute); err != nil {
+						b.Error(err)
+						return
+					}
+					if _, err := s.ReleaseSeat(seatID, userID); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMemoryStoreGetAllSeats reads the seat map of one event while
+// the store holds many others.
+func BenchmarkMemoryStoreGetAllSeats(b *testing.B) {
+	for _, events := range []int{1, 100, 1000} {
+		b.Run(fmt.Sprintf("events=%d", events), func(b *testing.B) {
+			s, _ := newBenchStore(b, events, 100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				seats, err := s.GetAllSeatsForEvent(1)
+				if err != nil || len(seats) != 100 {
+					b.Fatalf("got %d seats, %v", len(seats), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// MY RESERVATIONS
+// ----------------------------------------------------------------------
+
+// heldSeat is an active hold with the time left on it.
+type heldSeat struct {
+	*UserReservation
+	ExpiresIn int `json:"expires_in"` // seconds until the hold is released
+}
+
+// myReservationsResponse is the body of GET /me/reservations
+type myReservationsResponse struct {
+	Holds    []heldSeat         `json:"holds"`
+	Bookings []*UserReservation `json:"bookings"`
+}
+
+// myReservationsHandler -> GET /me/reservations?event_id=1
+// Lists the seats the caller currently holds, with the seconds left on each
+// hold, and the seats they have bought, newest first. event_id is optional.
+func myReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	var eventID int64
+	if v := r.URL.Query().Get("event_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, invalidRequest("invalid event ID"))
+			return
+		}
+		eventID = id
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := store.ListUserReservations(userID, eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	now := time.Now()
+	resp := myReservationsResponse{Holds: []heldSeat{}, Bookings: []*UserReservation{}}
+	for _, res := range list {
+		if res.Status == "completed" {
+			resp.Bookings = append(resp.Bookings, res)
+			continue
+		}
+		left := math.Ceil(res.ExpiresAt.Sub(now).Seconds())
+		resp.Holds = append(resp.Holds, heldSeat{UserReservation: res, ExpiresIn: int(math.Max(left, 0))})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,64 @@
+package main
+
+import "fmt"
+
+// ----------------------------------------------------------------------
+// SINGLE-SEAT GAPS
+// ----------------------------------------------------------------------
+//
+// A seat left empty between two taken seats is nearly impossible to sell,
+// since most buyers come in pairs or more. Events with
+// EventSettings.NoSingleSeatGaps reject reservations that would create one.
+
+// OrphanSeatError names the seat a reservation would leave stranded.
+type OrphanSeatError struct {
+	Seat *Seat
+}
+
+func (e *OrphanSeatError) Error() string {
+	s := e.Seat
+	where := fmt.Sprintf("row %d, seat %d", s.Row, s.Number)
+	if s.Section != "" {
+		where = s.Section + " " + where
+	}
+	return fmt.Sprintf("selection would leave seat %d (%s) as a single empty seat; include it or choose seats next to it", s.ID, where)
+}
+
+// findOrphanedSeat returns an available seat that taking the selected seats
+// would leave as the only free seat between two taken ones, or nil. nearby
+// must hold every seat within two places of a selected seat in its row; the
+// selected seats themselves may be included. Gaps that already existed are
+// not this selection's fault and are ignored.
+func findOrphanedSeat(selected, nearby []*Seat) *Seat {
+	type position struct {
+		section string
+		row     int
+		number  int
+	}
+	at := make(map[position]*Seat, len(nearby)+len(selected))
+	for _, seat := range nearby {
+		at[position{seat.Section, seat.Row, seat.Number}] = seat
+	}
+	chosen := make(map[int64]bool, len(selected))
+	for _, seat := range selected {
+		at[position{seat.Section, seat.Row, seat.Number}] = seat
+		chosen[seat.ID] = true
+	}
+	taken := func(seat *Seat) bool {
+		return chosen[seat.ID] || seat.Status != StatusAvailable
+	}
+
+	for _, seat := range selected {
+		for _, step := range []int{-1, 1} {
+			next := at[position{seat.Section, seat.Row, seat.Number + step}]
+			if next == nil || taken(next) {
+				continue
+			}
+			beyond := at[position{seat.Section, seat.Row, seat.Number + 2*step}]
+			if beyond != nil && taken(beyond) {
+				return next
+			}
+		}
+	}
+	return nil
+}
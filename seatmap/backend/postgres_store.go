@@ -0,0 +1,1334 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresStore persists seat maps in the events/tickets/reservations tables
+// (see db.sql). Every mutation runs in a transaction that locks the ticket row
+// with SELECT ... FOR UPDATE, the same approach as db-row-lock.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+// Reservation statuses as stored in the reservations table.
+const (
+	dbReservationPending   = "PENDING"
+	dbReservationConfirmed = "CONFIRMED"
+	dbReservationCancelled = "CANCELLED"
+	dbReservationExpired   = "EXPIRED"
+)
+
+// seatStatusToDB maps a SeatStatus to the tickets.status column.
+func seatStatusToDB(status SeatStatus) string {
+	return strings.ToUpper(string(status))
+}
+
+// eventColumns lists the events columns read by scanEvent, in order.
+const eventColumns = `id, name, venue, venue_id, date, settings`
+
+func scanEvent(row rowScanner) (*Event, error) {
+	var e Event
+	var venueID sql.NullInt64
+	if err := row.Scan(&e.ID, &e.Name, &e.Venue, &venueID, &e.StartTime, &e.Settings); err != nil {
+		return nil, err
+	}
+	e.VenueID = venueID.Int64
+	return &e, nil
+}
+
+// nullableID stores 0 as NULL for optional foreign keys.
+func nullableID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// ListEvents returns all events ordered by start time
+func (s *PostgresStore) ListEvents() ([]*Event, error) {
+	rows, err := s.db.Query(`SELECT ` + eventColumns + ` FROM events ORDER BY date`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// GetEvent returns a single event
+func (s *PostgresStore) GetEvent(eventID int64) (*Event, error) {
+	e, err := scanEvent(s.db.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = $1`, eventID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEventNotFound
+	}
+	return e, err
+}
+
+// CreateEvent inserts a new event and its seats in one transaction
+func (s *PostgresStore) CreateEvent(e *Event, seats []*Seat) (*Event, []*Seat, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	created := *e
+	err = tx.QueryRow(`INSERT INTO events (name, date, venue, venue_id, settings, total_seats, available_seats)
+		VALUES ($1, $2, $3, $4, $5, 0, 0) RETURNING id`,
+		e.Name, e.StartTime, e.Venue, nullableID(e.VenueID), e.Settings).Scan(&created.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	createdSeats, err := insertEventSeats(tx, created.ID, seats)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return &created, createdSeats, nil
+}
+
+// UpdateEvent overwrites the name, venue, venue ID, date and settings of an
+// event
+func (s *PostgresStore) UpdateEvent(e *Event) (*Event, error) {
+	res, err := s.db.Exec(`UPDATE events SET name = $1, date = $2, venue = $3, venue_id = $4, settings = $5 WHERE id = $6`,
+		e.Name, e.StartTime, e.Venue, nullableID(e.VenueID), e.Settings, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrEventNotFound
+	}
+	updated := *e
+	return &updated, nil
+}
+
+// DeleteEvent removes an event with all its seats and reservation history
+func (s *PostgresStore) DeleteEvent(eventID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := removeEventSeats(tx, eventID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM price_tiers WHERE event_id = $1`, eventID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE id = $1`, eventID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceEventSeats drops the current seats of an event and inserts new ones
+func (s *PostgresStore) ReplaceEventSeats(eventID int64, seats []*Seat) ([]*Seat, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := removeEventSeats(tx, eventID); err != nil {
+		return nil, err
+	}
+	created, err := insertEventSeats(tx, eventID, seats)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// insertEventSeats inserts available seats for an event and sets its seat
+// counts.
+func insertEventSeats(tx *sql.Tx, eventID int64, seats []*Seat) ([]*Seat, error) {
+	now := time.Now()
+	created := make([]*Seat, 0, len(seats))
+	for _, seat := range seats {
+		stored := *seat
+		stored.EventID = eventID
+		stored.Status = StatusAvailable
+		stored.UpdatedAt = now
+		err := tx.QueryRow(`INSERT INTO tickets (event_id, seat_row, seat_number, section, x, y,
+				accessible, obstructed_view, aisle, status, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+			eventID, stored.Row, stored.Number, stored.Section, stored.X, stored.Y,
+			stored.Accessible, stored.ObstructedView, stored.Aisle,
+			seatStatusToDB(StatusAvailable), now).Scan(&stored.ID)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, &stored)
+	}
+
+	if _, err := tx.Exec(`UPDATE events SET total_seats = $1, available_seats = $1 WHERE id = $2`,
+		len(created), eventID); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// removeEventSeats locks the event and deletes its tickets, reservations and
+// groups, refusing while any ticket is reserved or booked.
+func removeEventSeats(tx *sql.Tx, eventID int64) error {
+	var id int64
+	err := tx.QueryRow(`SELECT id FROM events WHERE id = $1 FOR UPDATE`, eventID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrEventNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Lock every ticket so no reservation can slip in before the delete
+	locked, err := queryIDs(tx, `SELECT id FROM tickets WHERE event_id = $1 ORDER BY id FOR UPDATE`, eventID)
+	if err != nil {
+		return err
+	}
+	if len(locked) > 0 {
+		var held int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM tickets WHERE event_id = $1 AND status <> $2`,
+			eventID, seatStatusToDB(StatusAvailable)).Scan(&held); err != nil {
+			return err
+		}
+		if held > 0 {
+			return ErrEventHasSales
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM seat_events WHERE ticket_id IN (SELECT id FROM tickets WHERE event_id = $1)`, eventID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM reservations WHERE ticket_id IN (SELECT id FROM tickets WHERE event_id = $1)`, eventID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM reservation_groups WHERE event_id = $1`, eventID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM tickets WHERE event_id = $1`, eventID)
+	return err
+}
+
+// ListVenues returns all venues without their sections
+func (s *PostgresStore) ListVenues() ([]*Venue, error) {
+	rows, err := s.db.Query(`SELECT id, name, width, height FROM venues ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var venues []*Venue
+	for rows.Next() {
+		var v Venue
+		if err := rows.Scan(&v.ID, &v.Name, &v.Width, &v.Height); err != nil {
+			return nil, err
+		}
+		venues = append(venues, &v)
+	}
+	return venues, rows.Err()
+}
+
+// GetVenue loads a whole venue layout
+func (s *PostgresStore) GetVenue(venueID int64) (*Venue, error) {
+	var v Venue
+	err := s.db.QueryRow(`SELECT id, name, width, height FROM venues WHERE id = $1`, venueID).
+		Scan(&v.ID, &v.Name, &v.Width, &v.Height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVenueNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT sec.id, sec.name, sec.kind,
+			vs.seat_row, vs.seat_number, vs.x, vs.y, vs.accessible, vs.obstructed_view, vs.aisle
+		FROM sections sec JOIN venue_seats vs ON vs.section_id = sec.id
+		WHERE sec.venue_id = $1
+		ORDER BY sec.position, vs.seat_row, vs.seat_number`, venueID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var current *Section
+	for rows.Next() {
+		var sec Section
+		var seat VenueSeat
+		if err := rows.Scan(&sec.ID, &sec.Name, &sec.Kind, &seat.Row, &seat.Number, &seat.X, &seat.Y,
+			&seat.Accessible, &seat.ObstructedView, &seat.Aisle); err != nil {
+			return nil, err
+		}
+		if current == nil || current.ID != sec.ID {
+			current = &sec
+			v.Sections = append(v.Sections, current)
+		}
+		current.Seats = append(current.Seats, &seat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// CreateVenue inserts a venue with its sections and seats in one transaction
+func (s *PostgresStore) CreateVenue(v *Venue) (*Venue, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created := copyVenue(v)
+	err = tx.QueryRow(`INSERT INTO venues (name, width, height) VALUES ($1, $2, $3) RETURNING id`,
+		v.Name, v.Width, v.Height).Scan(&created.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, sec := range created.Sections {
+		err := tx.QueryRow(`INSERT INTO sections (venue_id, name, kind, position)
+			VALUES ($1, $2, $3, $4) RETURNING id`, created.ID, sec.Name, sec.Kind, i).Scan(&sec.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, seat := range sec.Seats {
+			if _, err := tx.Exec(`INSERT INTO venue_seats (section_id, seat_row, seat_number, x, y,
+					accessible, obstructed_view, aisle)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				sec.ID, seat.Row, seat.Number, seat.X, seat.Y,
+				seat.Accessible, seat.ObstructedView, seat.Aisle); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetAllSeatsForEvent returns all seats for a given event
+func (s *PostgresStore) GetAllSeatsForEvent(eventID int64) ([]*Seat, error) {
+	rows, err := s.db.Query(`SELECT `+seatColumns+`
+		FROM tickets WHERE event_id = $1 ORDER BY section, seat_row, seat_number`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seats []*Seat
+	for rows.Next() {
+		seat, err := scanSeat(rows)
+		if err != nil {
+			return nil, err
+		}
+		seats = append(seats, seat)
+	}
+	return seats, rows.Err()
+}
+
+// GetSeat returns a single seat
+func (s *PostgresStore) GetSeat(seatID int64) (*Seat, error) {
+	row := s.db.QueryRow(`SELECT `+seatColumns+`
+		FROM tickets WHERE id = $1`, seatID)
+	seat, err := scanSeat(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSeatNotFound
+	}
+	return seat, err
+}
+
+// ReserveSeat locks the ticket row and reserves it if it is available
+func (s *PostgresStore) ReserveSeat(seatID, userID int64, duration time.Duration) (*Reservation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the ticket row
+	now := time.Now()
+	if _, err := lockSeatsForReservation(tx, []int64{seatID}, userID, now); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE tickets SET status = $1, updated_at = $2 WHERE id = $3`,
+		seatStatusToDB(StatusReserved), now, seatID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reservation{
+		SeatID:    seatID,
+		UserID:    userID,
+		ExpiresAt: now.Add(duration),
+		CreatedAt: now,
+		Status:    "active",
+	}
+	err = tx.QueryRow(`INSERT INTO reservations (ticket_id, user_id, created_at, expires_at, status)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		seatID, userID, r.CreatedAt, r.ExpiresAt, dbReservationPending).Scan(&r.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := logSeatEvents(tx, SeatEventReserved, userID, now, r.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// BookSeat finalizes the purchase if the seat is still reserved by that user
+func (s *PostgresStore) BookSeat(seatID, userID int64) (*Reservation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	seat, err := lockSeat(tx, seatID)
+	if err != nil {
+		return nil, err
+	}
+	if seat.Status != StatusReserved {
+		return nil, ErrSeatNotReserved
+	}
+
+	// Find the active reservation for this seat/user
+	res, err := lockPendingReservation(tx, seatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	seatStatus, resStatus := StatusBooked, dbReservationConfirmed
+	res.Status, res.PriceCents = "completed", seat.PriceCents
+	if now.After(res.ExpiresAt) {
+		seatStatus, resStatus = StatusAvailable, dbReservationExpired
+		res.Status, res.PriceCents = "expired", 0
+	}
+
+	if _, err := tx.Exec(`UPDATE tickets SET status = $1, updated_at = $2 WHERE id = $3`,
+		seatStatusToDB(seatStatus), now, seatID); err != nil {
+		return nil, err
+	}
+	// Record the price paid so later tier changes do not rewrite the sale
+	if _, err := tx.Exec(`UPDATE reservations SET status = $1, price_cents = $2 WHERE id = $3`,
+		resStatus, res.PriceCents, res.ID); err != nil {
+		return nil, err
+	}
+	event, actor := seatEventFor(res, res.Status)
+	if err := logSeatEvents(tx, event, actor, now, res.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if resStatus == dbReservationExpired {
+		return nil, ErrReservationExpired
+	}
+	return res, nil
+}
+
+// ReleaseSeat cancels the user's pending hold on a seat
+func (s *PostgresStore) ReleaseSeat(seatID, userID int64) (*Reservation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	seat, err := lockSeat(tx, seatID)
+	if err != nil {
+		return nil, err
+	}
+	if seat.Status != StatusReserved {
+		return nil, ErrSeatNotReserved
+	}
+
+	res, err := lockPendingReservation(tx, seatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	res.Status = "cancelled"
+
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE tickets SET status = $1, updated_at = $2 WHERE id = $3`,
+		seatStatusToDB(StatusAvailable), now, seatID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE reservations SET status = $1 WHERE id = $2`,
+		dbReservationCancelled, res.ID); err != nil {
+		return nil, err
+	}
+	if err := logSeatEvents(tx, SeatEventReleased, userID, now, res.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ExtendSeat pushes back the expiry of the user's pending hold on a seat
+func (s *PostgresStore) ExtendSeat(seatID, userID int64, duration, maxHold time.Duration) (*Reservation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	seat, err := lockSeat(tx, seatID)
+	if err != nil {
+		return nil, err
+	}
+	if seat.Status != StatusReserved {
+		return nil, ErrSeatNotReserved
+	}
+	res, err := lockPendingReservation(tx, seatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(res.ExpiresAt) {
+		if _, err := tx.Exec(`UPDATE tickets SET status = $1, updated_at = $2 WHERE id = $3`,
+			seatStatusToDB(StatusAvailable), now, seatID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`UPDATE reservations SET status = $1 WHERE id = $2`,
+			dbReservationExpired, res.ID); err != nil {
+			return nil, err
+		}
+		if err := logSeatEvents(tx, SeatEventExpired, 0, now, res.ID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrReservationExpired
+	}
+
+	res.ExpiresAt, err = extendedExpiry(res.CreatedAt, res.ExpiresAt, now, duration, maxHold)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE reservations SET expires_at = $1 WHERE id = $2`,
+		res.ExpiresAt, res.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// lockPendingReservation loads the user's pending, ungrouped reservation of
+// a seat with FOR UPDATE. The seat row must already be locked.
+func lockPendingReservation(tx *sql.Tx, seatID, userID int64) (*Reservation, error) {
+	res := &Reservation{SeatID: seatID, UserID: userID, Status: "active"}
+	var groupID sql.NullInt64
+	err := tx.QueryRow(`SELECT id, created_at, expires_at, group_id FROM reservations
+		WHERE ticket_id = $1 AND user_id = $2 AND status = $3 FOR UPDATE`,
+		seatID, userID, dbReservationPending).Scan(&res.ID, &res.CreatedAt, &res.ExpiresAt, &groupID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReservationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if groupID.Valid {
+		return nil, ErrReservationGrouped
+	}
+	return res, nil
+}
+
+// ReserveSeats locks all requested ticket rows in id order and reserves them
+// under one reservation group, or rolls back if any of them is unavailable.
+func (s *PostgresStore) ReserveSeats(seatIDs []int64, userID int64, duration time.Duration) (*ReservationGroup, error) {
+	if err := validateSeatIDs(seatIDs); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	seats, err := lockSeatsForReservation(tx, seatIDs, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &ReservationGroup{
+		EventID:   seats[0].EventID,
+		UserID:    userID,
+		SeatIDs:   append([]int64(nil), seatIDs...),
+		ExpiresAt: now.Add(duration),
+		CreatedAt: now,
+		Status:    "active",
+	}
+	err = tx.QueryRow(`INSERT INTO reservation_groups (event_id, user_id, created_at, expires_at, status)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		g.EventID, userID, now, g.ExpiresAt, dbReservationPending).Scan(&g.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE tickets SET status = $1, updated_at = $2 WHERE id = ANY($3)`,
+		seatStatusToDB(StatusReserved), now, pq.Array(seatIDs)); err != nil {
+		return nil, err
+	}
+	resIDs, err := queryIDs(tx, `INSERT INTO reservations (ticket_id, user_id, created_at, expires_at, status, group_id)
+		SELECT unnest($1::BIGINT[]), $2, $3, $4, $5, $6 RETURNING id`,
+		pq.Array(seatIDs), userID, now, g.ExpiresAt, dbReservationPending, g.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := logSeatEvents(tx, SeatEventReserved, userID, now, resIDs...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// BookGroup books every seat of a pending reservation group owned by the user
+func (s *PostgresStore) BookGroup(groupID, userID int64) (*ReservationGroup, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	g, seats, err := lockPendingGroup(tx, groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	seatIDs := g.SeatIDs
+
+	now := time.Now()
+	seatStatus, resStatus := StatusBooked, dbReservationConfirmed
+	if now.After(g.ExpiresAt) {
+		seatStatus, resStatus = StatusAvailable, dbReservationExpired
+	}
+
+	if _, err := tx.Exec(`UPDATE tickets SET status = $1, updated_at = $2 WHERE id = ANY($3)`,
+		seatStatusToDB(seatStatus), now, pq.Array(seatIDs)); err != nil {
+		return nil, err
+	}
+	// Record the price paid per seat; expired reservations record nothing
+	prices := make([]int64, len(seats))
+	for i, seat := range seats {
+		if resStatus == dbReservationConfirmed {
+			prices[i] = seat.PriceCents
+			g.TotalCents += seat.PriceCents
+		}
+	}
+	resIDs, err := queryIDs(tx, `UPDATE reservations r SET status = $1, price_cents = p.price_cents
+		FROM unnest($2::BIGINT[], $3::BIGINT[]) AS p(ticket_id, price_cents)
+		WHERE r.ticket_id = p.ticket_id AND r.group_id = $4 AND r.status = $5
+		RETURNING r.id`,
+		resStatus, pq.Array(seatIDs), pq.Array(prices), groupID, dbReservationPending)
+	if err != nil {
+		return nil, err
+	}
+	event, actor := SeatEventBooked, userID
+	if resStatus == dbReservationExpired {
+		event, actor = SeatEventExpired, 0
+	}
+	if err := logSeatEvents(tx, event, actor, now, resIDs...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE reservation_groups SET status = $1 WHERE id = $2`,
+		resStatus, groupID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if resStatus == dbReservationExpired {
+		g.Status = "expired"
+		return g, ErrReservationExpired
+	}
+	g.Status = "completed"
+	return g, nil
+}
+
+// ReleaseGroup cancels a pending reservation group owned by the user
+func (s *PostgresStore) ReleaseGroup(groupID, userID int64) (*ReservationGroup, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	g, _, err := lockPendingGroup(tx, groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := endGroup(tx, g, dbReservationCancelled, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	g.Status = "cancelled"
+	return g, nil
+}
+
+// ExtendGroup pushes back the expiry of every seat of a pending group
+func (s *PostgresStore) ExtendGroup(groupID, userID int64, duration, maxHold time.Duration) (*ReservationGroup, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	g, _, err := lockPendingGroup(tx, groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(g.ExpiresAt) {
+		if err := endGroup(tx, g, dbReservationExpired, now); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		g.Status = "expired"
+		return g, ErrReservationExpired
+	}
+
+	g.ExpiresAt, err = extendedExpiry(g.CreatedAt, g.ExpiresAt, now, duration, maxHold)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE reservations SET expires_at = $1 WHERE group_id = $2 AND status = $3`,
+		g.ExpiresAt, groupID, dbReservationPending); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE reservation_groups SET expires_at = $1 WHERE id = $2`,
+		g.ExpiresAt, groupID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// lockPendingGroup locks the tickets of a group and then the group row, the
+// same order as the sweeper, and checks the group is pending and the user's.
+func lockPendingGroup(tx *sql.Tx, groupID, userID int64) (*ReservationGroup, []*Seat, error) {
+	seatIDs, err := queryIDs(tx, `SELECT ticket_id FROM reservations WHERE group_id = $1 ORDER BY ticket_id`, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	seats, err := lockSeats(tx, seatIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	g := &ReservationGroup{ID: groupID, SeatIDs: seatIDs, Status: "active"}
+	var status string
+	err = tx.QueryRow(`SELECT event_id, user_id, created_at, expires_at, status
+		FROM reservation_groups WHERE id = $1 FOR UPDATE`, groupID).
+		Scan(&g.EventID, &g.UserID, &g.CreatedAt, &g.ExpiresAt, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrReservationNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if g.UserID != userID || status != dbReservationPending {
+		return nil, nil, ErrReservationNotFound
+	}
+	return g, seats, nil
+}
+
+// endGroup gives up the pending reservations of a locked group with the
+// given status and frees their seats.
+func endGroup(tx *sql.Tx, g *ReservationGroup, status string, now time.Time) error {
+	if _, err := tx.Exec(`UPDATE tickets SET status = $1, updated_at = $2 WHERE id = ANY($3) AND status = $4`,
+		seatStatusToDB(StatusAvailable), now, pq.Array(g.SeatIDs), seatStatusToDB(StatusReserved)); err != nil {
+		return err
+	}
+	resIDs, err := queryIDs(tx, `UPDATE reservations SET status = $1 WHERE group_id = $2 AND status = $3 RETURNING id`,
+		status, g.ID, dbReservationPending)
+	if err != nil {
+		return err
+	}
+	event, actor := SeatEventReleased, g.UserID
+	if status == dbReservationExpired {
+		event, actor = SeatEventExpired, 0
+	}
+	if err := logSeatEvents(tx, event, actor, now, resIDs...); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE reservation_groups SET status = $1 WHERE id = $2`, status, g.ID)
+	return err
+}
+
+// ListPriceTiers returns the price tiers of an event ordered by ID
+func (s *PostgresStore) ListPriceTiers(eventID int64) ([]*PriceTier, error) {
+	if _, err := s.GetEvent(eventID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT id, event_id, name, price_cents, currency
+		FROM price_tiers WHERE event_id = $1 ORDER BY id`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tiers := []*PriceTier{}
+	for rows.Next() {
+		var t PriceTier
+		if err := rows.Scan(&t.ID, &t.EventID, &t.Name, &t.PriceCents, &t.Currency); err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, &t)
+	}
+	return tiers, rows.Err()
+}
+
+// CreatePriceTier adds a price tier to an event
+func (s *PostgresStore) CreatePriceTier(t *PriceTier) (*PriceTier, error) {
+	if _, err := s.GetEvent(t.EventID); err != nil {
+		return nil, err
+	}
+	created := *t
+	err := s.db.QueryRow(`INSERT INTO price_tiers (event_id, name, price_cents, currency)
+		VALUES ($1, $2, $3, $4) RETURNING id`, t.EventID, t.Name, t.PriceCents, t.Currency).Scan(&created.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdatePriceTier changes an existing tier of the same event
+func (s *PostgresStore) UpdatePriceTier(t *PriceTier) (*PriceTier, error) {
+	res, err := s.db.Exec(`UPDATE price_tiers SET name = $1, price_cents = $2, currency = $3
+		WHERE id = $4 AND event_id = $5`, t.Name, t.PriceCents, t.Currency, t.ID, t.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrPriceTierNotFound
+	}
+	updated := *t
+	return &updated, nil
+}
+
+// AssignPriceTiers sets the tier of whole sections, then of single seats
+func (s *PostgresStore) AssignPriceTiers(eventID int64, a PriceAssignment) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`SELECT id FROM events WHERE id = $1`, eventID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrEventNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	checkTier := func(tierID int64) error {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM price_tiers WHERE id = $1 AND event_id = $2`,
+			tierID, eventID).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrPriceTierNotFound
+		}
+		return nil
+	}
+
+	for section, tierID := range a.Sections {
+		if err := checkTier(tierID); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE tickets SET price_tier_id = $1 WHERE event_id = $2 AND section = $3`,
+			tierID, eventID, section)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrSectionNotFound
+		}
+	}
+	for seatID, tierID := range a.Seats {
+		if err := checkTier(tierID); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE tickets SET price_tier_id = $1 WHERE event_id = $2 AND id = $3`,
+			tierID, eventID, seatID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrSeatNotFound
+		}
+	}
+	return tx.Commit()
+}
+
+// ExpireReservations frees the seats of all pending reservations past their
+// expiry. Ticket rows are locked first (the same order BookSeat uses) and rows
+// held by an in-flight booking are skipped until the next sweep.
+func (s *PostgresStore) ExpireReservations(now time.Time) ([]*Seat, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ticketIDs, err := queryIDs(tx, `SELECT t.id FROM tickets t
+		JOIN reservations r ON r.ticket_id = t.id
+		WHERE r.status = $1 AND r.expires_at < $2
+		ORDER BY t.id FOR UPDATE OF t SKIP LOCKED`, dbReservationPending, now)
+	if err != nil || len(ticketIDs) == 0 {
+		return nil, err
+	}
+
+	resIDs, err := queryIDs(tx, `UPDATE reservations SET status = $1
+		WHERE ticket_id = ANY($2) AND status = $3 AND expires_at < $4
+		RETURNING id`,
+		dbReservationExpired, pq.Array(ticketIDs), dbReservationPending, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := logSeatEvents(tx, SeatEventExpired, 0, now, resIDs...); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE reservation_groups SET status = $1
+		WHERE id IN (SELECT group_id FROM reservations WHERE ticket_id = ANY($2) AND status = $1)
+		AND status = $3`,
+		dbReservationExpired, pq.Array(ticketIDs), dbReservationPending); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`UPDATE tickets SET status = $1, updated_at = $2
+		WHERE id = ANY($3) AND status = $4
+		RETURNING `+seatColumns,
+		seatStatusToDB(StatusAvailable), now, pq.Array(ticketIDs), seatStatusToDB(StatusReserved))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var freed []*Seat
+	for rows.Next() {
+		seat, err := scanSeat(rows)
+		if err != nil {
+			return nil, err
+		}
+		freed = append(freed, seat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return freed, nil
+}
+
+// SeatHistory returns the log of a seat, oldest first
+func (s *PostgresStore) SeatHistory(seatID int64) ([]*SeatEvent, error) {
+	if _, err := s.GetSeat(seatID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT id, ticket_id, event_id, type, user_id, actor_id, reservation_id, group_id, created_at
+		FROM seat_events WHERE ticket_id = $1 ORDER BY id`, seatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*SeatEvent{}
+	for rows.Next() {
+		var e SeatEvent
+		var actorID, groupID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.SeatID, &e.EventID, &e.Type, &e.UserID, &actorID,
+			&e.ReservationID, &groupID, &e.At); err != nil {
+			return nil, err
+		}
+		e.ActorID, e.GroupID = actorID.Int64, groupID.Int64
+		history = append(history, &e)
+	}
+	return history, rows.Err()
+}
+
+// ReplaySeatLog sets every ticket with a log to the status of its last entry.
+// The tickets table is normally updated together with the log, so anything
+// found here was changed behind the store's back.
+//
+// The log is read from a REPEATABLE READ snapshot instead of locking it, so
+// booking is not held up at startup. A transition that commits a ticket
+// after the snapshot was taken makes the UPDATE fail with a serialization
+// error, and the replay starts again from a fresh snapshot.
+func (s *PostgresStore) ReplaySeatLog() ([]*Seat, error) {
+	for attempt := 1; ; attempt++ {
+		fixed, err := s.replaySeatLog()
+		var pqErr *pq.Error
+		if err != nil && errors.As(err, &pqErr) && pqErr.Code == "40001" && attempt < replaySeatLogAttempts {
+			continue
+		}
+		return fixed, err
+	}
+}
+
+// replaySeatLogAttempts is how often ReplaySeatLog retries after losing a
+// race with a transition.
+const replaySeatLogAttempts = 5
+
+func (s *PostgresStore) replaySeatLog() ([]*Seat, error) {
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`WITH last AS (
+			SELECT DISTINCT ON (ticket_id) ticket_id, type, created_at
+			FROM seat_events ORDER BY ticket_id, id DESC
+		), want AS (
+			SELECT ticket_id, created_at AS logged_at,
+				CASE type WHEN $1 THEN $2 WHEN $3 THEN $4 ELSE $5 END AS logged_status
+			FROM last
+		)
+		UPDATE tickets SET status = want.logged_status, updated_at = want.logged_at
+		FROM want WHERE tickets.id = want.ticket_id AND tickets.status <> want.logged_status
+		RETURNING `+seatColumns,
+		string(SeatEventReserved), seatStatusToDB(StatusReserved),
+		string(SeatEventBooked), seatStatusToDB(StatusBooked),
+		seatStatusToDB(StatusAvailable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fixed []*Seat
+	for rows.Next() {
+		seat, err := scanSeat(rows)
+		if err != nil {
+			return nil, err
+		}
+		fixed = append(fixed, seat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return fixed, nil
+}
+
+// ListUserReservations returns the user's pending holds that have not
+// expired yet and confirmed bookings, newest first
+func (s *PostgresStore) ListUserReservations(userID, eventID int64) ([]*UserReservation, error) {
+	rows, err := s.db.Query(`SELECT r.id, r.ticket_id, r.created_at, r.expires_at, r.status, r.group_id, r.price_cents
+		FROM reservations r JOIN tickets t ON t.id = r.ticket_id
+		WHERE r.user_id = $1 AND ($2 = 0 OR t.event_id = $2)
+		AND (r.status = $3 OR (r.status = $4 AND r.expires_at > $5))
+		ORDER BY r.created_at DESC, r.id DESC`,
+		userID, eventID, dbReservationConfirmed, dbReservationPending, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*UserReservation
+	var seatIDs []int64
+	for rows.Next() {
+		r := &Reservation{UserID: userID}
+		var status string
+		var groupID, priceCents sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.SeatID, &r.CreatedAt, &r.ExpiresAt, &status, &groupID, &priceCents); err != nil {
+			return nil, err
+		}
+		r.Status = "active"
+		if status == dbReservationConfirmed {
+			r.Status = "completed"
+		}
+		r.GroupID, r.PriceCents = groupID.Int64, priceCents.Int64
+		list = append(list, &UserReservation{Reservation: r})
+		seatIDs = append(seatIDs, r.SeatID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	seatRows, err := s.db.Query(`SELECT `+seatColumns+` FROM tickets WHERE id = ANY($1)`, pq.Array(seatIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer seatRows.Close()
+	seats := make(map[int64]*Seat, len(seatIDs))
+	for seatRows.Next() {
+		seat, err := scanSeat(seatRows)
+		if err != nil {
+			return nil, err
+		}
+		seats[seat.ID] = seat
+	}
+	if err := seatRows.Err(); err != nil {
+		return nil, err
+	}
+	for _, ur := range list {
+		ur.Seat = seats[ur.SeatID]
+	}
+	return list, nil
+}
+
+// seatColumns lists the tickets columns read by scanSeat, in order.
+const seatColumns = `id, seat_row, seat_number, section, x, y,
+	accessible, obstructed_view, aisle, status, event_id, updated_at, price_tier_id,
+	COALESCE((SELECT p.price_cents FROM price_tiers p WHERE p.id = tickets.price_tier_id), 0)`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSeat(row rowScanner) (*Seat, error) {
+	var seat Seat
+	var status string
+	var priceTierID sql.NullInt64
+	if err := row.Scan(&seat.ID, &seat.Row, &seat.Number, &seat.Section, &seat.X, &seat.Y,
+		&seat.Accessible, &seat.ObstructedView, &seat.Aisle, &status, &seat.EventID, &seat.UpdatedAt,
+		&priceTierID, &seat.PriceCents); err != nil {
+		return nil, err
+	}
+	seat.PriceTierID = priceTierID.Int64
+	seat.Status = SeatStatus(strings.ToLower(status))
+	return &seat, nil
+}
+
+// lockSeatsForReservation locks the seats userID wants to reserve and
+// checks them against the event's rules: they must be available and of one
+// event, must not leave a single-seat gap if the event forbids those, and
+// must keep the user within the event's purchase limits. Holds count while
+// they expire after now.
+//
+// Neighbours needed for the gap check are locked in the same id-ordered
+// pass as the seats, and a per-user advisory lock taken first serializes
+// the limit check, so concurrent requests cannot slip past either rule.
+func lockSeatsForReservation(tx *sql.Tx, seatIDs []int64, userID int64, now time.Time) ([]*Seat, error) {
+	var eventID int64
+	var settings EventSettings
+	err := tx.QueryRow(`SELECT e.id, e.settings FROM events e JOIN tickets t ON t.event_id = e.id
+		WHERE t.id = $1`, seatIDs[0]).Scan(&eventID, &settings)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSeatNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if settings.hasPurchaseLimits() {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtextextended('seatmap-user:' || $1 || ':' || $2, 0))`,
+			eventID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	var seats, nearby []*Seat
+	if !settings.NoSingleSeatGaps {
+		if seats, err = lockSeats(tx, seatIDs); err != nil {
+			return nil, err
+		}
+	} else {
+		rows, err := tx.Query(`SELECT `+seatColumns+` FROM tickets WHERE id IN (
+				SELECT n.id FROM tickets s JOIN tickets n ON n.event_id = s.event_id
+					AND n.section = s.section AND n.seat_row = s.seat_row
+					AND abs(n.seat_number - s.seat_number) <= 2
+				WHERE s.id = ANY($1))
+			ORDER BY id FOR UPDATE`, pq.Array(seatIDs))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		wanted := make(map[int64]bool, len(seatIDs))
+		for _, id := range seatIDs {
+			wanted[id] = true
+		}
+		for rows.Next() {
+			seat, err := scanSeat(rows)
+			if err != nil {
+				return nil, err
+			}
+			if wanted[seat.ID] {
+				seats = append(seats, seat)
+			} else {
+				nearby = append(nearby, seat)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if len(seats) != len(seatIDs) {
+			return nil, ErrSeatNotFound
+		}
+	}
+
+	for _, seat := range seats {
+		if seat.Status != StatusAvailable {
+			return nil, ErrSeatNotAvailable
+		}
+		if seat.EventID != seats[0].EventID {
+			return nil, ErrSeatsSpanEvents
+		}
+	}
+	if settings.NoSingleSeatGaps {
+		if orphan := findOrphanedSeat(seats, nearby); orphan != nil {
+			return nil, &OrphanSeatError{Seat: orphan}
+		}
+	}
+
+	if settings.hasPurchaseLimits() {
+		var held, bought int
+		err := tx.QueryRow(`SELECT
+				count(*) FILTER (WHERE r.status = $3 AND r.expires_at > $5),
+				count(*) FILTER (WHERE r.status = $4)
+			FROM reservations r JOIN tickets t ON t.id = r.ticket_id
+			WHERE t.event_id = $1 AND r.user_id = $2`,
+			eventID, userID, dbReservationPending, dbReservationConfirmed, now).Scan(&held, &bought)
+		if err != nil {
+			return nil, err
+		}
+		if err := settings.checkPurchaseLimits(held, bought, len(seats)); err != nil {
+			return nil, err
+		}
+	}
+	return seats, nil
+}
+
+// lockSeats loads several ticket rows with FOR UPDATE in id order, so two
+// overlapping multi-seat requests cannot deadlock each other.
+func lockSeats(tx *sql.Tx, seatIDs []int64) ([]*Seat, error) {
+	rows, err := tx.Query(`SELECT `+seatColumns+`
+		FROM tickets WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(seatIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seats []*Seat
+	for rows.Next() {
+		seat, err := scanSeat(rows)
+		if err != nil {
+			return nil, err
+		}
+		seats = append(seats, seat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(seats) != len(seatIDs) {
+		return nil, ErrSeatNotFound
+	}
+	return seats, nil
+}
+
+// logSeatEvents appends a transition of each reservation's seat to the seat
+// log, in the transaction that makes the change.
+func logSeatEvents(tx *sql.Tx, event SeatEventType, actorID int64, now time.Time, reservationIDs ...int64) error {
+	_, err := tx.Exec(`INSERT INTO seat_events (ticket_id, event_id, type, user_id, actor_id, reservation_id, group_id, created_at)
+		SELECT r.ticket_id, t.event_id, $1, r.user_id, $2, r.id, r.group_id, $3
+		FROM reservations r JOIN tickets t ON t.id = r.ticket_id
+		WHERE r.id = ANY($4) ORDER BY r.ticket_id`,
+		string(event), nullableID(actorID), now, pq.Array(reservationIDs))
+	return err
+}
+
+// queryIDs runs a query returning a single BIGINT column and collects it.
+func queryIDs(tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// lockSeat loads a ticket row with FOR UPDATE inside the given transaction.
+func lockSeat(tx *sql.Tx, seatID int64) (*Seat, error) {
+	row := tx.QueryRow(`SELECT `+seatColumns+`
+		FROM tickets WHERE id = $1 FOR UPDATE`, seatID)
+	seat, err := scanSeat(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSeatNotFound
+	}
+	return seat, err
+}
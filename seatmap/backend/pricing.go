@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ----------------------------------------------------------------------
+// PRICE TIERS
+// ----------------------------------------------------------------------
+
+// PriceTier is a named price level of an event, e.g. "Premium" or "Standard".
+// Seats point at a tier; the price a buyer pays is copied onto their
+// reservation when they book.
+type PriceTier struct {
+	ID         int64  `json:"id"`
+	EventID    int64  `json:"event_id"`
+	Name       string `json:"name"`
+	PriceCents int64  `json:"price_cents"`
+	Currency   string `json:"currency"`
+}
+
+// PriceAssignment maps section names and seat IDs to price tier IDs.
+type PriceAssignment struct {
+	Sections map[string]int64 `json:"sections,omitempty"`
+	Seats    map[int64]int64  `json:"seats,omitempty"`
+}
+
+const defaultCurrency = "USD"
+
+// validate checks a tier before it is stored and fills in the default currency.
+func (t *PriceTier) validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return invalidRequest("price tier name is required")
+	}
+	if t.PriceCents < 0 {
+		return invalidRequest("price_cents must not be negative")
+	}
+	if t.Currency == "" {
+		t.Currency = defaultCurrency
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// PRICE HANDLERS
+// ----------------------------------------------------------------------
+
+// listPriceTiersHandler -> GET /events/{eventID}/price-tiers
+func listPriceTiersHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, ok := parseIDSegment(w, r, 1, "event")
+	if !ok {
+		return
+	}
+	tiers, err := store.ListPriceTiers(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tiers)
+}
+
+// createPriceTierHandler -> POST /admin/events/{eventID}/price-tiers
+func createPriceTierHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	eventID, ok := parseIDSegment(w, r, 2, "event")
+	if !ok {
+		return
+	}
+	var t PriceTier
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	t.EventID = eventID
+	if err := t.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	created, err := store.CreatePriceTier(&t)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// updatePriceTierHandler -> PUT /admin/events/{eventID}/price-tiers/{tierID}
+func updatePriceTierHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	eventID, ok := parseIDSegment(w, r, 2, "event")
+	if !ok {
+		return
+	}
+	tierID, ok := parseIDSegment(w, r, 4, "price tier")
+	if !ok {
+		return
+	}
+	var t PriceTier
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	t.ID, t.EventID = tierID, eventID
+	if err := t.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	updated, err := store.UpdatePriceTier(&t)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	broadcastSeatMap(eventID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// assignPricesHandler -> POST /admin/events/{eventID}/prices
+// Body: {"sections": {"Floor": 1}, "seats": {"42": 2}}
+func assignPricesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	eventID, ok := parseIDSegment(w, r, 2, "event")
+	if !ok {
+		return
+	}
+	var a PriceAssignment
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+
+	if err := store.AssignPriceTiers(eventID, a); err != nil {
+		writeError(w, err)
+		return
+	}
+	broadcastSeatMap(eventID)
+
+	seats, err := store.GetAllSeatsForEvent(eventID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(seats)
+}
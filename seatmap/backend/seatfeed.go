@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// SEAT FEED
+// ----------------------------------------------------------------------
+//
+// The seat feed turns seat mutations into a versioned stream of per-seat
+// changes. A new SSE client gets one "snapshot" with the current version and
+// afterwards only "seat" events, each carrying its version as the SSE id.
+// When the browser reconnects it sends that id back as Last-Event-ID and we
+// replay the changes it missed from a bounded in-memory log, falling back to
+// a fresh snapshot when the log no longer reaches back that far.
+
+// SeatChange is one entry in an event's change log.
+type SeatChange struct {
+	Version int64 `json:"version"`
+	SeatID  int64 `json:"seat_id"`
+	Seat    *Seat `json:"seat,omitempty"`    // new state, nil when removed
+	Removed bool  `json:"removed,omitempty"` // seat no longer exists
+}
+
+// SeatSnapshot is the full seat map of an event at a version.
+type SeatSnapshot struct {
+	Version int64   `json:"version"`
+	Seats   []*Seat `json:"seats"`
+}
+
+// eventFeed is the published state of one event.
+type eventFeed struct {
+	version int64
+	seats   map[int64]*Seat // what subscribers have been told
+	log     []SeatChange    // most recent changes, oldest first
+}
+
+// SeatFeed keeps the change logs of all events that have had subscribers.
+type SeatFeed struct {
+	mu      sync.Mutex
+	sse     *SSEManager
+	feeds   map[int64]*eventFeed
+	logSize int
+	// epoch prefixes every SSE id so ids from before a restart, when the
+	// version counters started over, are never mistaken for current ones.
+	epoch string
+	// backplane shares updates with other instances; nil keeps them in
+	// this process.
+	backplane Backplane
+}
+
+func NewSeatFeed(sse *SSEManager, logSize int) *SeatFeed {
+	return &SeatFeed{
+		sse:     sse,
+		feeds:   make(map[int64]*eventFeed),
+		logSize: logSize,
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// Subscribe registers an SSE client for an event and returns the messages
+// it must be sent first: the changes after lastEventID when they can be
+// replayed, otherwise a snapshot. Both happen under the feed lock, so no
+// change can fall between the initial messages and the subscription.
+func (f *SeatFeed) Subscribe(eventID int64, lastEventID string) (*SSESubscription, []SSEMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	feed, err := f.load(eventID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var initial []SSEMessage
+	if changes, ok := f.since(feed, lastEventID); ok {
+		for _, c := range changes {
+			initial = append(initial, f.changeMessage(c))
+		}
+	} else {
+		initial = append(initial, f.snapshotMessage(feed))
+	}
+
+	return f.sse.Subscribe(eventID), initial, nil
+}
+
+// Publish pushes the current state of the given seats to subscribers.
+// Seats whose state did not change are skipped.
+func (f *SeatFeed) Publish(eventID int64, seatIDs ...int64) {
+	// Other instances may be watching even if nobody here is
+	if f.backplane == nil && !f.watching(eventID) {
+		return
+	}
+
+	seats := make([]*Seat, 0, len(seatIDs))
+	for _, id := range seatIDs {
+		seat, err := store.GetSeat(id)
+		if err != nil {
+			continue
+		}
+		seats = append(seats, seat)
+	}
+	f.PublishSeats(eventID, seats)
+}
+
+// PublishSeats pushes already loaded seat states to subscribers.
+func (f *SeatFeed) PublishSeats(eventID int64, seats []*Seat) {
+	f.applySeats(eventID, seats)
+	if f.backplane != nil && len(seats) > 0 {
+		f.backplane.Publish(SeatUpdate{EventID: eventID, Seats: seats})
+	}
+}
+
+// applySeats publishes seat states to this instance's subscribers.
+func (f *SeatFeed) applySeats(eventID int64, seats []*Seat) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	feed, ok := f.feeds[eventID]
+	if !ok {
+		return
+	}
+	for _, seat := range seats {
+		f.apply(eventID, feed, seat)
+	}
+}
+
+// Refresh reloads every seat of an event and publishes the differences.
+// Use it after bulk changes such as regenerating seats or repricing.
+func (f *SeatFeed) Refresh(eventID int64) {
+	if f.backplane != nil {
+		f.backplane.Publish(SeatUpdate{EventID: eventID, Refresh: true})
+	}
+	f.refresh(eventID)
+}
+
+// receive applies an update from another instance.
+func (f *SeatFeed) receive(u SeatUpdate) {
+	if u.Refresh {
+		f.refresh(u.EventID)
+		return
+	}
+	f.applySeats(u.EventID, u.Seats)
+}
+
+// refreshAll refreshes every event with subscribers on this instance.
+func (f *SeatFeed) refreshAll() {
+	f.mu.Lock()
+	eventIDs := make([]int64, 0, len(f.feeds))
+	for eventID := range f.feeds {
+		eventIDs = append(eventIDs, eventID)
+	}
+	f.mu.Unlock()
+
+	for _, eventID := range eventIDs {
+		f.refresh(eventID)
+	}
+}
+
+// refresh is Refresh for this instance only.
+func (f *SeatFeed) refresh(eventID int64) {
+	if !f.watching(eventID) {
+		return
+	}
+	seats, err := store.GetAllSeatsForEvent(eventID)
+	if err != nil {
+		log.Printf("failed to refresh seat feed of event %d: %v", eventID, err)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	feed, ok := f.feeds[eventID]
+	if !ok {
+		return
+	}
+	current := make(map[int64]bool, len(seats))
+	for _, seat := range seats {
+		current[seat.ID] = true
+		f.apply(eventID, feed, seat)
+	}
+	for id := range feed.seats {
+		if !current[id] {
+			delete(feed.seats, id)
+			f.record(eventID, feed, SeatChange{SeatID: id, Removed: true})
+		}
+	}
+}
+
+// watching reports whether anyone ever subscribed to the event.
+func (f *SeatFeed) watching(eventID int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.feeds[eventID]
+	return ok
+}
+
+// load returns the feed of an event, reading its seats on first use.
+// Feeds are never dropped, so only events that exist get one; otherwise
+// every made-up ID a client streams would stay in f.feeds for good.
+// The caller must hold f.mu.
+func (f *SeatFeed) load(eventID int64) (*eventFeed, error) {
+	if feed, ok := f.feeds[eventID]; ok {
+		return feed, nil
+	}
+	if _, err := store.GetEvent(eventID); err != nil {
+		return nil, err
+	}
+	seats, err := store.GetAllSeatsForEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	feed := &eventFeed{seats: make(map[int64]*Seat, len(seats))}
+	for _, seat := range seats {
+		feed.seats[seat.ID] = seat
+	}
+	f.feeds[eventID] = feed
+	return feed, nil
+}
+
+// apply records a seat state if it differs from what was last published.
+// A state older than the published one (a slower request finishing late)
+// is ignored. The caller must hold f.mu.
+func (f *SeatFeed) apply(eventID int64, feed *eventFeed, seat *Seat) {
+	if prev, ok := feed.seats[seat.ID]; ok {
+		if seat.UpdatedAt.Before(prev.UpdatedAt) || sameSeatState(prev, seat) {
+			return
+		}
+	}
+	feed.seats[seat.ID] = seat
+	f.record(eventID, feed, SeatChange{SeatID: seat.ID, Seat: seat})
+}
+
+// sameSeatState reports whether two states of a seat differ only in their
+// timestamps, which subscribers do not need to hear about.
+func sameSeatState(a, b *Seat) bool {
+	x, y := *a, *b
+	x.UpdatedAt, y.UpdatedAt = time.Time{}, time.Time{}
+	return x == y
+}
+
+// record assigns the next version to a change, appends it to the log and
+// broadcasts it. The caller must hold f.mu.
+func (f *SeatFeed) record(eventID int64, feed *eventFeed, c SeatChange) {
+	feed.version++
+	c.Version = feed.version
+	feed.log = append(feed.log, c)
+	if len(feed.log) > f.logSize {
+		feed.log = feed.log[len(feed.log)-f.logSize:]
+	}
+	f.sse.Broadcast(eventID, f.changeMessage(c))
+}
+
+// since returns the changes after the version encoded in lastEventID, or
+// false if the client must start over from a snapshot. The caller must
+// hold f.mu.
+func (f *SeatFeed) since(feed *eventFeed, lastEventID string) ([]SeatChange, bool) {
+	epoch, versionStr, ok := strings.Cut(lastEventID, "-")
+	if !ok || epoch != f.epoch {
+		return nil, false
+	}
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version > feed.version {
+		return nil, false
+	}
+	if version == feed.version {
+		return nil, true
+	}
+	if len(feed.log) == 0 || feed.log[0].Version > version+1 {
+		return nil, false // the log no longer covers the gap
+	}
+	return feed.log[version+1-feed.log[0].Version:], true
+}
+
+func (f *SeatFeed) eventID(version int64) string {
+	return fmt.Sprintf("%s-%d", f.epoch, version)
+}
+
+// snapshotMessage builds the "snapshot" SSE message. The caller must hold f.mu.
+func (f *SeatFeed) snapshotMessage(feed *eventFeed) SSEMessage {
+	snap := SeatSnapshot{Version: feed.version, Seats: make([]*Seat, 0, len(feed.seats))}
+	for _, seat := range feed.seats {
+		snap.Seats = append(snap.Seats, seat)
+	}
+	sort.Slice(snap.Seats, func(i, j int) bool { return snap.Seats[i].ID < snap.Seats[j].ID })
+	data, _ := json.Marshal(snap)
+	return SSEMessage{ID: f.eventID(feed.version), Event: "snapshot", Data: string(data)}
+}
+
+func (f *SeatFeed) changeMessage(c SeatChange) SSEMessage {
+	data, _ := json.Marshal(c)
+	return SSEMessage{ID: f.eventID(c.Version), Event: "seat", Data: string(data)}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// SEAT LOG
+// ----------------------------------------------------------------------
+//
+// Seat.Status only tells what a seat is now. To settle a disputed booking
+// we also need who held it and when, so every transition is appended to a
+// per-seat log in the same step that changes the seat:
+//
+//	reserved  a user put a hold on the seat
+//	released  the holder gave the hold up
+//	expired   the hold ran out (actor 0: nobody did it)
+//	booked    the holder bought the seat
+//
+// Entries are never changed afterwards. On startup the stores replay the
+// log and correct any seat whose status disagrees with its last entry.
+
+// SeatEventType is the kind of seat transition.
+type SeatEventType string
+
+const (
+	SeatEventReserved SeatEventType = "reserved"
+	SeatEventReleased SeatEventType = "released"
+	SeatEventExpired  SeatEventType = "expired"
+	SeatEventBooked   SeatEventType = "booked"
+)
+
+// SeatEvent is one entry of a seat's log.
+type SeatEvent struct {
+	ID            int64         `json:"id"`
+	SeatID        int64         `json:"seat_id"`
+	EventID       int64         `json:"event_id"`
+	Type          SeatEventType `json:"type"`
+	UserID        int64         `json:"user_id"`  // holder of the reservation
+	ActorID       int64         `json:"actor_id"` // who caused it; 0 for the system
+	ReservationID int64         `json:"reservation_id"`
+	GroupID       int64         `json:"group_id,omitempty"`
+	At            time.Time     `json:"at"`
+}
+
+// seatStatusAfter returns the status a seat has after a transition.
+func seatStatusAfter(t SeatEventType) SeatStatus {
+	switch t {
+	case SeatEventReserved:
+		return StatusReserved
+	case SeatEventBooked:
+		return StatusBooked
+	default:
+		return StatusAvailable
+	}
+}
+
+// seatEventFor returns the transition that ends a reservation with the given
+// status ("completed", "cancelled" or "expired") and who caused it.
+func seatEventFor(r *Reservation, status string) (SeatEventType, int64) {
+	switch status {
+	case "completed":
+		return SeatEventBooked, r.UserID
+	case "expired":
+		return SeatEventExpired, 0
+	default:
+		return SeatEventReleased, r.UserID
+	}
+}
+
+// seatHistoryHandler -> GET /seats/{id}/history
+// Lists every transition of a seat, oldest first. Admins only, since the
+// log names the users who held the seat.
+func seatHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	parts := splitPath(r.URL.Path)
+	if len(parts) != 3 {
+		writeError(w, invalidRequest("invalid path; expected /seats/{id}/history"))
+		return
+	}
+	seatID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		writeError(w, invalidRequest("invalid seat ID"))
+		return
+	}
+
+	history, err := store.SeatHistory(seatID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// ----------------------------------------------------------------------
+// EVENT SETTINGS
+// ----------------------------------------------------------------------
+
+// EventSettings are the per-event sales rules an admin can change without
+// touching the seats. The zero value means "no special rules".
+type EventSettings struct {
+	// SeatRanking orders seats for best-available searches.
+	SeatRanking *SeatRanking `json:"seat_ranking,omitempty"`
+	// NoSingleSeatGaps rejects reservations that would leave one empty seat
+	// between two taken ones.
+	NoSingleSeatGaps bool `json:"no_single_seat_gaps,omitempty"`
+	// MaxHeldSeatsPerUser caps how many seats one user may hold at once;
+	// 0 means no limit.
+	MaxHeldSeatsPerUser int `json:"max_held_seats_per_user,omitempty"`
+	// MaxTicketsPerUser caps how many seats one user may hold and buy for
+	// the event in total; 0 means no limit.
+	MaxTicketsPerUser int `json:"max_tickets_per_user,omitempty"`
+}
+
+// Validate checks settings before they are stored.
+func (s EventSettings) Validate() error {
+	if s.MaxHeldSeatsPerUser < 0 || s.MaxTicketsPerUser < 0 {
+		return invalidRequest("purchase limits must not be negative")
+	}
+	if s.SeatRanking != nil {
+		return s.SeatRanking.Validate()
+	}
+	return nil
+}
+
+func (s EventSettings) hasPurchaseLimits() bool {
+	return s.MaxHeldSeatsPerUser > 0 || s.MaxTicketsPerUser > 0
+}
+
+// checkPurchaseLimits reports whether a user who holds held seats and has
+// bought bought seats of the event may reserve n more.
+func (s EventSettings) checkPurchaseLimits(held, bought, n int) error {
+	if s.MaxHeldSeatsPerUser > 0 && held+n > s.MaxHeldSeatsPerUser {
+		return ErrTooManyHeldSeats
+	}
+	if s.MaxTicketsPerUser > 0 && held+bought+n > s.MaxTicketsPerUser {
+		return ErrTicketLimitReached
+	}
+	return nil
+}
+
+// Value stores the settings as JSON in the events.settings column.
+func (s EventSettings) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan reads the settings back from the events.settings column.
+func (s *EventSettings) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case nil:
+		*s = EventSettings{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into EventSettings", src)
+	}
+}
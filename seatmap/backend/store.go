@@ -0,0 +1,125 @@
+package main
+
+import "time"
+
+// SeatStore is the persistence boundary for seat maps. Handlers only talk to
+// a SeatStore, so the same API can run against the in-memory maps (demo and
+// tests) or against Postgres (real data that survives a restart).
+type SeatStore interface {
+	// GetAllSeatsForEvent returns a snapshot of every seat of an event.
+	GetAllSeatsForEvent(eventID int64) ([]*Seat, error)
+	// GetSeat returns a snapshot of a single seat or ErrSeatNotFound.
+	GetSeat(seatID int64) (*Seat, error)
+	// ReserveSeat puts a hold on an available seat for the given duration.
+	ReserveSeat(seatID, userID int64, duration time.Duration) (*Reservation, error)
+	// BookSeat finalizes the purchase if the seat is still held by that user
+	// and returns the completed reservation with the price paid. There is
+	// no order or payment step here; see db-row-lock for those.
+	BookSeat(seatID, userID int64) (*Reservation, error)
+	// ReleaseSeat cancels the user's active hold on a seat and makes the seat
+	// available again.
+	ReleaseSeat(seatID, userID int64) (*Reservation, error)
+	// ExtendSeat moves the expiry of the user's hold on a seat to duration
+	// from now, but never past maxHold after the hold was created.
+	ExtendSeat(seatID, userID int64, duration, maxHold time.Duration) (*Reservation, error)
+	// ReserveSeats holds every listed seat under one ReservationGroup, or
+	// none of them if any seat cannot be reserved.
+	ReserveSeats(seatIDs []int64, userID int64, duration time.Duration) (*ReservationGroup, error)
+	// BookGroup finalizes every seat of a reservation group owned by the user.
+	// If the group has expired its seats are released and the group is
+	// returned together with ErrReservationExpired.
+	BookGroup(groupID, userID int64) (*ReservationGroup, error)
+	// ReleaseGroup cancels an active reservation group and frees its seats.
+	ReleaseGroup(groupID, userID int64) (*ReservationGroup, error)
+	// ExtendGroup extends the expiry of every seat of a group like
+	// ExtendSeat. An expired group is released and returned together with
+	// ErrReservationExpired, as in BookGroup.
+	ExtendGroup(groupID, userID int64, duration, maxHold time.Duration) (*ReservationGroup, error)
+	// ListEvents returns every event ordered by start time.
+	ListEvents() ([]*Event, error)
+	// GetEvent returns a single event or ErrEventNotFound.
+	GetEvent(eventID int64) (*Event, error)
+	// CreateEvent stores a new event together with its seats (which may be
+	// none) and assigns their IDs. Either both are stored or neither is.
+	CreateEvent(e *Event, seats []*Seat) (*Event, []*Seat, error)
+	// UpdateEvent overwrites the name, venue, venue ID, start time and
+	// settings of an event.
+	UpdateEvent(e *Event) (*Event, error)
+	// DeleteEvent removes an event and its seats; it fails with
+	// ErrEventHasSales while any seat is reserved or booked.
+	DeleteEvent(eventID int64) error
+	// ReplaceEventSeats swaps the seats of an event for the given ones and
+	// assigns their IDs; it fails with ErrEventHasSales like DeleteEvent.
+	ReplaceEventSeats(eventID int64, seats []*Seat) ([]*Seat, error)
+	// ListVenues returns every venue without its sections.
+	ListVenues() ([]*Venue, error)
+	// GetVenue returns a whole venue layout or ErrVenueNotFound.
+	GetVenue(venueID int64) (*Venue, error)
+	// CreateVenue stores a venue layout and assigns venue and section IDs.
+	CreateVenue(v *Venue) (*Venue, error)
+	// ListPriceTiers returns the price tiers of an event.
+	ListPriceTiers(eventID int64) ([]*PriceTier, error)
+	// CreatePriceTier adds a price tier to an event and assigns its ID.
+	CreatePriceTier(t *PriceTier) (*PriceTier, error)
+	// UpdatePriceTier changes the name, price and currency of a tier. Seats
+	// already booked keep the price recorded on their reservation.
+	UpdatePriceTier(t *PriceTier) (*PriceTier, error)
+	// AssignPriceTiers points seats at tiers, first whole sections and then
+	// individual seats, so per-seat assignments override their section.
+	AssignPriceTiers(eventID int64, a PriceAssignment) error
+	// ExpireReservations marks every active reservation that expired before
+	// now as "expired", frees its seat and returns the freed seats.
+	ExpireReservations(now time.Time) ([]*Seat, error)
+	// ListUserReservations returns the user's unexpired active holds and
+	// completed bookings with their seats, newest first. An eventID of 0
+	// lists every event.
+	ListUserReservations(userID, eventID int64) ([]*UserReservation, error)
+	// SeatHistory returns every logged transition of a seat, oldest first,
+	// or ErrSeatNotFound.
+	SeatHistory(seatID int64) ([]*SeatEvent, error)
+	// ReplaySeatLog sets every seat that has a log to the status its last
+	// entry leads to and returns the seats that had to be corrected.
+	ReplaySeatLog() ([]*Seat, error)
+}
+
+// UserReservation is one of a user's reservations with the seat it is for.
+type UserReservation struct {
+	*Reservation
+	Seat *Seat `json:"seat"`
+}
+
+// validateSeatIDs checks a multi-seat request before any seat is touched.
+func validateSeatIDs(seatIDs []int64) error {
+	if len(seatIDs) == 0 {
+		return ErrNoSeatsRequested
+	}
+	if len(seatIDs) > maxSeatsPerGroup {
+		return ErrTooManySeats
+	}
+	seen := make(map[int64]bool, len(seatIDs))
+	for _, id := range seatIDs {
+		if seen[id] {
+			return ErrDuplicateSeat
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// extendedExpiry returns the expiry of a hold extended to duration from now,
+// capped at maxHold after the hold was created. An extension never shortens
+// a hold, and a hold already at the cap cannot be extended.
+func extendedExpiry(createdAt, expiresAt, now time.Time, duration, maxHold time.Duration) (time.Time, error) {
+	limit := createdAt.Add(maxHold)
+	if !expiresAt.Before(limit) {
+		return time.Time{}, ErrHoldLimitReached
+	}
+	next := now.Add(duration)
+	if next.After(limit) {
+		next = limit
+	}
+	if next.Before(expiresAt) {
+		next = expiresAt
+	}
+	return next, nil
+}
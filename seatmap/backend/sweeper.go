@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runExpirySweeper expires stale holds every interval until ctx is cancelled.
+// Without it an expired reservation only frees its seat when the same user
+// tries to book, so nobody else could ever buy that seat.
+func runExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sweepExpiredReservations(now)
+			idempotencyCache.prune(now)
+		}
+	}
+}
+
+// sweepExpiredReservations runs one expiry pass and pushes the freed seats to
+// SSE subscribers of every affected event.
+func sweepExpiredReservations(now time.Time) {
+	freed, err := store.ExpireReservations(now)
+	if err != nil {
+		log.Printf("expiry sweep failed: %v", err)
+		return
+	}
+	byEvent := make(map[int64][]*Seat)
+	for _, seat := range freed {
+		byEvent[seat.EventID] = append(byEvent[seat.EventID], seat)
+	}
+	for eventID, seats := range byEvent {
+		seatFeed.PublishSeats(eventID, seats)
+	}
+}
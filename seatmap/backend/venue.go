@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ----------------------------------------------------------------------
+// VENUE MODEL
+// ----------------------------------------------------------------------
+
+// Venue is the physical hall an event takes place in. Its JSON form is the
+// import/export format for whole layouts: POST it to /admin/venues and read
+// it back from GET /venues/{id}.
+type Venue struct {
+	ID       int64      `json:"id"`
+	Name     string     `json:"name"`
+	Width    float64    `json:"width"` // size of the drawing the seat coordinates refer to
+	Height   float64    `json:"height"`
+	Sections []*Section `json:"sections,omitempty"`
+}
+
+// Section is a named area of a venue such as "Floor", "Balcony" or "Box 3".
+type Section struct {
+	ID    int64        `json:"id"`
+	Name  string       `json:"name"`
+	Kind  string       `json:"kind,omitempty"` // free-form: "floor", "balcony", "box", ...
+	Seats []*VenueSeat `json:"seats"`
+}
+
+// VenueSeat is a seat position in a venue layout. Events copy these into
+// their own Seat rows, which carry the per-event status.
+type VenueSeat struct {
+	Row    int     `json:"row"`
+	Number int     `json:"number"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	SeatAttributes
+}
+
+// Validate checks that a venue layout can be stored and turned into seats.
+func (v *Venue) Validate() error {
+	if strings.TrimSpace(v.Name) == "" {
+		return invalidRequest("venue name is required")
+	}
+	if len(v.Sections) == 0 {
+		return invalidRequest("venue needs at least one section")
+	}
+
+	names := make(map[string]bool, len(v.Sections))
+	total := 0
+	for _, sec := range v.Sections {
+		if strings.TrimSpace(sec.Name) == "" {
+			return invalidRequest("every section needs a name")
+		}
+		if names[sec.Name] {
+			return invalidRequest(fmt.Sprintf("section %q listed twice", sec.Name))
+		}
+		names[sec.Name] = true
+		if len(sec.Seats) == 0 {
+			return invalidRequest(fmt.Sprintf("section %q has no seats", sec.Name))
+		}
+
+		positions := make(map[[2]int]bool, len(sec.Seats))
+		for _, seat := range sec.Seats {
+			if seat.Row <= 0 || seat.Number <= 0 {
+				return invalidRequest(fmt.Sprintf("section %q has a seat with non-positive row or number", sec.Name))
+			}
+			pos := [2]int{seat.Row, seat.Number}
+			if positions[pos] {
+				return invalidRequest(fmt.Sprintf("section %q lists row %d seat %d twice", sec.Name, seat.Row, seat.Number))
+			}
+			positions[pos] = true
+		}
+
+		total += len(sec.Seats)
+		if total > maxSeatsPerEvent {
+			return invalidRequest(fmt.Sprintf("venue exceeds %d seats", maxSeatsPerEvent))
+		}
+	}
+	return nil
+}
+
+// seatsFromVenue copies every seat of a venue layout into unsaved event seats.
+func seatsFromVenue(v *Venue) []*Seat {
+	var seats []*Seat
+	for _, sec := range v.Sections {
+		for _, vs := range sec.Seats {
+			seats = append(seats, &Seat{
+				Row:            vs.Row,
+				Number:         vs.Number,
+				Section:        sec.Name,
+				X:              vs.X,
+				Y:              vs.Y,
+				Status:         StatusAvailable,
+				SeatAttributes: vs.SeatAttributes,
+			})
+		}
+	}
+	return seats
+}
+
+// copyVenue deep-copies a venue so callers cannot mutate stored layouts.
+func copyVenue(v *Venue) *Venue {
+	copyV := *v
+	copyV.Sections = make([]*Section, len(v.Sections))
+	for i, sec := range v.Sections {
+		copySec := *sec
+		copySec.Seats = make([]*VenueSeat, len(sec.Seats))
+		for j, seat := range sec.Seats {
+			copySeat := *seat
+			copySec.Seats[j] = &copySeat
+		}
+		copyV.Sections[i] = &copySec
+	}
+	return &copyV
+}
+
+// ----------------------------------------------------------------------
+// VENUE HANDLERS
+// ----------------------------------------------------------------------
+
+// listVenuesHandler -> GET /venues
+// Returns venue summaries without their sections.
+func listVenuesHandler(w http.ResponseWriter, r *http.Request) {
+	venues, err := store.ListVenues()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(venues)
+}
+
+// exportVenueHandler -> GET /venues/{venueID}
+// Returns the whole layout in the same format /admin/venues imports.
+func exportVenueHandler(w http.ResponseWriter, r *http.Request) {
+	venueID, ok := parseIDSegment(w, r, 1, "venue")
+	if !ok {
+		return
+	}
+	v, err := store.GetVenue(venueID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// importVenueHandler -> POST /admin/venues
+// Stores a whole venue layout; IDs in the body are ignored and reassigned.
+func importVenueHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	var v Venue
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		writeError(w, ErrInvalidBody)
+		return
+	}
+	if err := v.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	created, err := store.CreateVenue(&v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
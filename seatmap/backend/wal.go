@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// DURABLE MEMORY STORE
+// ----------------------------------------------------------------------
+//
+// With -data-dir the memory store survives restarts. Every change is written
+// to a write-ahead log and fsynced before the lock protecting it is released,
+// so a caller never sees a sale that is not on disk. Records hold the state
+// after the change (the seat, its reservation, group and log entries) rather
+// than the call that made it, so replaying them gives back the same IDs and
+// times instead of holding seats anew.
+//
+// Every -snapshot-interval the whole store is written to snapshot.json and
+// the WAL segments it covers are deleted. On boot the snapshot is loaded and
+// the newer records are applied on top.
+//
+// A write that fails after memory has changed leaves the two disagreeing,
+// so the process exits instead; the next start recovers what was synced.
+
+const (
+	snapshotFile  = "snapshot.json"
+	walFilePrefix = "wal-"
+	walFileSuffix = ".log"
+)
+
+// walRecord is one WAL entry. Op "update" upserts everything it carries into
+// the event's shard, creating the shard if Event is set; the other ops
+// mirror the catalog methods of the same name.
+type walRecord struct {
+	Seq          uint64              `json:"seq"`
+	Op           string              `json:"op"` // "update", "delete_event", "replace_seats" or "venue"
+	EventID      int64               `json:"event_id,omitempty"`
+	Event        *Event              `json:"event,omitempty"`
+	Seats        []*Seat             `json:"seats,omitempty"`
+	Reservations []*Reservation      `json:"reservations,omitempty"`
+	Groups       []*ReservationGroup `json:"groups,omitempty"`
+	PriceTiers   []*PriceTier        `json:"price_tiers,omitempty"`
+	SeatEvents   []*SeatEvent        `json:"seat_events,omitempty"`
+	Venue        *Venue              `json:"venue,omitempty"`
+}
+
+// storeSnapshot is the content of snapshot.json.
+type storeSnapshot struct {
+	Seq      uint64        `json:"seq"` // last WAL record included
+	Counters storeCounters `json:"counters"`
+	Venues   []*Venue      `json:"venues"`
+	Events   []*walRecord  `json:"events"` // one "update" per event
+	TakenAt  time.Time     `json:"taken_at"`
+}
+
+// storeCounters keeps IDs of deleted objects from being handed out again.
+type storeCounters struct {
+	NextEventID       int64 `json:"next_event_id"`
+	NextSeatID        int64 `json:"next_seat_id"`
+	NextVenueID       int64 `json:"next_venue_id"`
+	NextSectionID     int64 `json:"next_section_id"`
+	LastReservationID int64 `json:"last_reservation_id"`
+	LastGroupID       int64 `json:"last_group_id"`
+	LastPriceTierID   int64 `json:"last_price_tier_id"`
+	LastSeatEventID   int64 `json:"last_seat_event_id"`
+}
+
+// WAL appends records to numbered segment files in a directory.
+type WAL struct {
+	dir string
+
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64 // last record written
+}
+
+// append assigns the next sequence number to rec and writes it durably.
+func (w *WAL) append(rec *walRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec.Seq = w.seq + 1
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Fatalf("wal: failed to encode record: %v", err)
+	}
+	data = append(data, '\n')
+	if _, err := w.file.Write(data); err != nil {
+		log.Fatalf("wal: failed to write record %d: %v", rec.Seq, err)
+	}
+	if err := w.file.Sync(); err != nil {
+		log.Fatalf("wal: failed to sync record %d: %v", rec.Seq, err)
+	}
+	w.seq = rec.Seq
+}
+
+// rotate starts a new segment and returns the last sequence number written
+// to the older ones.
+func (w *WAL) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.openSegment(w.seq + 1); err != nil {
+		return 0, err
+	}
+	return w.seq, nil
+}
+
+// openSegment closes the current segment, if any, and creates the one whose
+// first record will be firstSeq. The caller must hold w.mu.
+func (w *WAL) openSegment(firstSeq uint64) error {
+	name := filepath.Join(w.dir, fmt.Sprintf("%s%020d%s", walFilePrefix, firstSeq, walFileSuffix))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		f.Close()
+		return err
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = f
+	return nil
+}
+
+// removeSegmentsThrough deletes the segments that only hold records up to
+// seq, which a snapshot now covers.
+func (w *WAL) removeSegmentsThrough(seq uint64) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	// A segment ends where the next one starts; the last one is still open
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i+1].firstSeq-1 > seq {
+			break
+		}
+		if err := os.Remove(segments[i].path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type walSegment struct {
+	path     string
+	firstSeq uint64
+}
+
+// walSegments lists the segment files of dir in order.
+func walSegments(dir string) ([]walSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []walSegment
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, walFilePrefix) || !strings.HasSuffix(name, walFileSuffix) {
+			continue
+		}
+		var first uint64
+		if _, err := fmt.Sscanf(strings.TrimPrefix(name, walFilePrefix), "%d", &first); err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{path: filepath.Join(dir, name), firstSeq: first})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].firstSeq < segments[j].firstSeq })
+	return segments, nil
+}
+
+// readSegment calls fn for every record of a segment. A record without its
+// newline was cut short by a crash; its write never returned, so it is cut
+// off the file.
+func readSegment(path string, fn func(*walRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) == 0 {
+				return nil
+			}
+			log.Printf("wal: dropping incomplete last record of %s", filepath.Base(path))
+			return os.Truncate(path, offset)
+		}
+		if err != nil {
+			return err
+		}
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		if err := fn(&rec); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+	}
+}
+
+// OpenMemoryStore recovers a memory store from dir, creating the directory
+// if needed, and logs every later change there. fresh reports that dir held
+// no data yet.
+func OpenMemoryStore(dir string) (s *MemoryStore, fresh bool, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, false, err
+	}
+	s = NewMemoryStore()
+	fresh = true
+
+	var seq uint64
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	switch {
+	case err == nil:
+		var snap storeSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", snapshotFile, err)
+		}
+		if err := s.restore(&snap); err != nil {
+			return nil, false, err
+		}
+		seq, fresh = snap.Seq, false
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, false, err
+	}
+
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	replayed := 0
+	for _, seg := range segments {
+		err := readSegment(seg.path, func(rec *walRecord) error {
+			if rec.Seq <= seq {
+				return nil // already in the snapshot
+			}
+			if rec.Seq != seq+1 {
+				return fmt.Errorf("wal: record %d follows %d", rec.Seq, seq)
+			}
+			seq = rec.Seq
+			replayed++
+			return s.apply(rec)
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if replayed > 0 {
+		fresh = false
+	}
+	if !fresh {
+		log.Printf("recovered memory store from %s (%d WAL records after the snapshot)", dir, replayed)
+	}
+
+	s.wal = &WAL{dir: dir, seq: seq}
+	if err := s.wal.openSegment(seq + 1); err != nil {
+		return nil, false, err
+	}
+	for _, sh := range s.events {
+		sh.wal = s.wal
+	}
+	return s, fresh, nil
+}
+
+// restore loads a snapshot into an empty store.
+func (s *MemoryStore) restore(snap *storeSnapshot) error {
+	for _, v := range snap.Venues {
+		if err := s.apply(&walRecord{Op: "venue", Venue: v}); err != nil {
+			return err
+		}
+	}
+	for _, rec := range snap.Events {
+		if err := s.apply(rec); err != nil {
+			return err
+		}
+	}
+	c := snap.Counters
+	s.eventIDCounter = max(s.eventIDCounter, c.NextEventID)
+	s.seatIDCounter = max(s.seatIDCounter, c.NextSeatID)
+	s.venueIDCounter = max(s.venueIDCounter, c.NextVenueID)
+	s.sectionIDCounter = max(s.sectionIDCounter, c.NextSectionID)
+	bumpCounter(&s.reservationCounter, c.LastReservationID)
+	bumpCounter(&s.groupCounter, c.LastGroupID)
+	bumpCounter(&s.priceTierCounter, c.LastPriceTierID)
+	bumpCounter(&s.seatEventCounter, c.LastSeatEventID)
+	return nil
+}
+
+// apply redoes one WAL record. It runs before the store is shared, so it
+// takes no locks.
+func (s *MemoryStore) apply(rec *walRecord) error {
+	switch rec.Op {
+	case "venue":
+		v := copyVenue(rec.Venue)
+		s.venues[v.ID] = v
+		s.venueIDCounter = max(s.venueIDCounter, v.ID+1)
+		for _, sec := range v.Sections {
+			s.sectionIDCounter = max(s.sectionIDCounter, sec.ID+1)
+		}
+	case "delete_event":
+		sh, found := s.events[rec.EventID]
+		if !found {
+			return fmt.Errorf("wal: record %d deletes unknown event %d", rec.Seq, rec.EventID)
+		}
+		if err := s.removeEventSeats(sh); err != nil {
+			return err
+		}
+		delete(s.events, rec.EventID)
+	case "replace_seats":
+		sh, found := s.events[rec.EventID]
+		if !found {
+			return fmt.Errorf("wal: record %d replaces seats of unknown event %d", rec.Seq, rec.EventID)
+		}
+		if err := s.removeEventSeats(sh); err != nil {
+			return err
+		}
+		s.applyUpdate(sh, rec)
+	case "update":
+		sh, found := s.events[rec.EventID]
+		if !found {
+			if rec.Event == nil {
+				return fmt.Errorf("wal: record %d updates unknown event %d", rec.Seq, rec.EventID)
+			}
+			sh = newEventShard(nil, &s.seatEventCounter, nil)
+			s.events[rec.EventID] = sh
+		}
+		s.applyUpdate(sh, rec)
+	default:
+		return fmt.Errorf("wal: record %d has unknown op %q", rec.Seq, rec.Op)
+	}
+	return nil
+}
+
+// applyUpdate upserts the content of a record into a shard.
+func (s *MemoryStore) applyUpdate(sh *eventShard, rec *walRecord) {
+	if rec.Event != nil {
+		e := *rec.Event
+		sh.event = &e
+		s.eventIDCounter = max(s.eventIDCounter, e.ID+1)
+	}
+	for _, t := range rec.PriceTiers {
+		copyTier := *t
+		sh.priceTiers[t.ID] = &copyTier
+		bumpCounter(&s.priceTierCounter, t.ID)
+	}
+	for _, seat := range rec.Seats {
+		copySeat := *seat
+		sh.seats[seat.ID] = &copySeat
+		s.seatEvents[seat.ID] = rec.EventID
+		s.seatIDCounter = max(s.seatIDCounter, seat.ID+1)
+	}
+	for _, g := range rec.Groups {
+		sh.groups[g.ID] = copyGroup(g)
+		s.groupEvents.Store(g.ID, rec.EventID)
+		bumpCounter(&s.groupCounter, g.ID)
+	}
+	for _, r := range rec.Reservations {
+		copyRes := *r
+		sh.reservations[r.ID] = &copyRes
+		if copyRes.Status == "active" {
+			sh.held[r.SeatID] = &copyRes
+		} else if held := sh.held[r.SeatID]; held != nil && held.ID == r.ID {
+			delete(sh.held, r.SeatID)
+		}
+		bumpCounter(&s.reservationCounter, r.ID)
+	}
+	for _, e := range rec.SeatEvents {
+		copyEvent := *e
+		sh.history[e.SeatID] = append(sh.history[e.SeatID], &copyEvent)
+		bumpCounter(&s.seatEventCounter, e.ID)
+	}
+}
+
+// Snapshot writes the whole store to snapshot.json and drops the WAL
+// segments it makes redundant. Sales wait while the state is copied.
+func (s *MemoryStore) Snapshot() error {
+	if s.wal == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	shards := make([]*eventShard, 0, len(s.events))
+	for _, sh := range s.events {
+		sh.mu.Lock()
+		shards = append(shards, sh)
+	}
+	data, seq, err := s.encodeSnapshot(shards)
+	for _, sh := range shards {
+		sh.mu.Unlock()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dir := s.wal.dir
+	tmp := filepath.Join(dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	return s.wal.removeSegmentsThrough(seq)
+}
+
+// encodeSnapshot rotates the WAL and encodes the state it leaves behind.
+// The caller must hold s.mu and the lock of every shard, so no change is
+// half way into the WAL.
+func (s *MemoryStore) encodeSnapshot(shards []*eventShard) ([]byte, uint64, error) {
+	seq, err := s.wal.rotate()
+	if err != nil {
+		return nil, 0, err
+	}
+	snap := storeSnapshot{
+		Seq: seq,
+		Counters: storeCounters{
+			NextEventID:       s.eventIDCounter,
+			NextSeatID:        s.seatIDCounter,
+			NextVenueID:       s.venueIDCounter,
+			NextSectionID:     s.sectionIDCounter,
+			LastReservationID: s.reservationCounter.Load(),
+			LastGroupID:       s.groupCounter.Load(),
+			LastPriceTierID:   s.priceTierCounter.Load(),
+			LastSeatEventID:   s.seatEventCounter.Load(),
+		},
+		Venues:  make([]*Venue, 0, len(s.venues)),
+		Events:  make([]*walRecord, 0, len(shards)),
+		TakenAt: time.Now(),
+	}
+	for _, v := range s.venues {
+		snap.Venues = append(snap.Venues, v)
+	}
+	for _, sh := range shards {
+		rec := &walRecord{Op: "update", EventID: sh.event.ID, Event: sh.event}
+		for _, seat := range sh.seats {
+			rec.Seats = append(rec.Seats, seat)
+		}
+		for _, r := range sh.reservations {
+			rec.Reservations = append(rec.Reservations, r)
+		}
+		for _, g := range sh.groups {
+			rec.Groups = append(rec.Groups, g)
+		}
+		for _, t := range sh.priceTiers {
+			rec.PriceTiers = append(rec.PriceTiers, t)
+		}
+		for _, history := range sh.history {
+			rec.SeatEvents = append(rec.SeatEvents, history...)
+		}
+		snap.Events = append(snap.Events, rec)
+	}
+	data, err := json.Marshal(snap)
+	return data, seq, err
+}
+
+// runSnapshots takes a snapshot every interval until ctx is cancelled.
+func (s *MemoryStore) runSnapshots(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				log.Printf("snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// logCatalog writes a record for a catalog change. The caller must hold
+// s.mu.
+func (s *MemoryStore) logCatalog(rec *walRecord) {
+	if s.wal != nil {
+		s.wal.append(rec)
+	}
+}
+
+// ----------------------------------------------------------------------
+// Change tracking
+// ----------------------------------------------------------------------
+
+// shardChanges collects what changed under one hold of a shard lock, so
+// unlock can write it to the WAL as one record.
+type shardChanges struct {
+	event        bool
+	seats        map[int64]bool
+	reservations map[int64]bool
+	groups       map[int64]bool
+	priceTiers   map[int64]bool
+	seatEvents   []*SeatEvent
+}
+
+func (c *shardChanges) empty() bool {
+	return !c.event && len(c.seats) == 0 && len(c.reservations) == 0 &&
+		len(c.groups) == 0 && len(c.priceTiers) == 0 && len(c.seatEvents) == 0
+}
+
+// markReservation records that a reservation and its seat changed.
+// The caller must hold sh.mu; the mark* methods do nothing without a WAL.
+func (sh *eventShard) markReservation(r *Reservation) {
+	if sh.wal == nil {
+		return
+	}
+	markID(&sh.changes.reservations, r.ID)
+	markID(&sh.changes.seats, r.SeatID)
+}
+
+func (sh *eventShard) markGroup(g *ReservationGroup) {
+	if sh.wal != nil {
+		markID(&sh.changes.groups, g.ID)
+	}
+}
+
+func (sh *eventShard) markSeat(seatID int64) {
+	if sh.wal != nil {
+		markID(&sh.changes.seats, seatID)
+	}
+}
+
+func (sh *eventShard) markPriceTier(tierID int64) {
+	if sh.wal != nil {
+		markID(&sh.changes.priceTiers, tierID)
+	}
+}
+
+func (sh *eventShard) markEvent() {
+	if sh.wal != nil {
+		sh.changes.event = true
+	}
+}
+
+func (sh *eventShard) markSeatEvent(e *SeatEvent) {
+	if sh.wal != nil {
+		sh.changes.seatEvents = append(sh.changes.seatEvents, e)
+	}
+}
+
+func markID(set *map[int64]bool, id int64) {
+	if *set == nil {
+		*set = make(map[int64]bool)
+	}
+	(*set)[id] = true
+}
+
+// unlock writes the changes made under the shard lock to the WAL and then
+// releases the lock. Methods that change a shard unlock it with this.
+func (sh *eventShard) unlock() {
+	if sh.wal != nil && !sh.changes.empty() {
+		sh.wal.append(sh.changeRecord())
+		sh.changes = shardChanges{}
+	}
+	sh.mu.Unlock()
+}
+
+// changeRecord builds the WAL record of the pending changes.
+// The caller must hold sh.mu.
+func (sh *eventShard) changeRecord() *walRecord {
+	c := &sh.changes
+	rec := &walRecord{Op: "update", EventID: sh.event.ID, SeatEvents: c.seatEvents}
+	if c.event {
+		rec.Event = sh.event
+	}
+	for id := range c.seats {
+		if seat, found := sh.seats[id]; found {
+			rec.Seats = append(rec.Seats, seat)
+		}
+	}
+	for id := range c.reservations {
+		rec.Reservations = append(rec.Reservations, sh.reservations[id])
+	}
+	for id := range c.groups {
+		rec.Groups = append(rec.Groups, sh.groups[id])
+	}
+	for id := range c.priceTiers {
+		rec.PriceTiers = append(rec.PriceTiers, sh.priceTiers[id])
+	}
+	return rec
+}
+
+// ----------------------------------------------------------------------
+// Files
+// ----------------------------------------------------------------------
+
+// bumpCounter moves an ID counter up to id.
+func bumpCounter(c *atomic.Int64, id int64) {
+	if id > c.Load() {
+		c.Store(id)
+	}
+}
+
+// writeFileSync writes data to path and fsyncs it.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir fsyncs a directory so renames and new files in it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
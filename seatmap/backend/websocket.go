@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ----------------------------------------------------------------------
+// WEBSOCKET TRANSPORT
+// ----------------------------------------------------------------------
+//
+// GET /events/{id}/seats/ws carries the same seat feed as the SSE stream,
+// one JSON message per SSE event:
+//
+//	{"type": "snapshot", "id": "<id>", "data": {"version": 3, "seats": [...]}}
+//	{"type": "seat", "id": "<id>", "data": {"version": 4, "seat_id": 1, "seat": {...}}}
+//	{"type": "resync", "data": {"reason": "..."}}
+//
+// Pass the last received id as ?last_event_id= when reconnecting to get the
+// missed changes instead of a snapshot. Clients may also send commands for
+// seats of the same event, which need a bearer token (the Authorization
+// header or ?access_token=):
+//
+//	{"type": "reserve", "request_id": "r1", "seat_id": 1, "duration": 300}
+//	{"type": "release", "request_id": "r2", "seat_id": 1}
+//
+// Each command is answered with a "result" carrying the reservation or an
+// "error", echoing its request_id. Errors carry the same code and message
+// as HTTP error responses:
+//
+//	{"type": "error", "request_id": "r1", "error": {"code": "SEAT_NOT_AVAILABLE", "message": "seat not available"}}
+
+// wsCommand is a message sent by a WebSocket client.
+type wsCommand struct {
+	Type      string `json:"type"` // "reserve" or "release"
+	RequestID string `json:"request_id,omitempty"`
+	SeatID    int64  `json:"seat_id"`
+	Duration  int    `json:"duration,omitempty"` // seconds, reserve only; as in POST /seats/{id}/reserve
+}
+
+// wsMessage is a message sent to a WebSocket client.
+type wsMessage struct {
+	Type        string          `json:"type"`
+	ID          string          `json:"id,omitempty"`
+	RequestID   string          `json:"request_id,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Reservation *Reservation    `json:"reservation,omitempty"`
+	Error       *errorBody      `json:"error,omitempty"` // as in HTTP error responses
+}
+
+// wsMaxCommandSize caps incoming messages; commands are tiny.
+const wsMaxCommandSize = 4096
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// The HTTP API allows any origin as well; commands still need a token.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// seatSocketHandler -> GET /events/{eventID}/seats/ws
+func seatSocketHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, ok := parseIDSegment(w, r, 1, "event")
+	if !ok {
+		return
+	}
+
+	sub, initial, err := seatFeed.Subscribe(eventID, r.URL.Query().Get("last_event_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer func() {
+		sseManager.Unsubscribe(eventID, sub)
+	}()
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an HTTP error
+	}
+	defer conn.Close()
+
+	// Commands are read on their own goroutine; this one does all writes.
+	replies := make(chan wsMessage, 16)
+	readerDone := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(readerDone)
+		readSeatCommands(conn, r, eventID, replies, quit)
+	}()
+
+	for _, msg := range initial {
+		if err := writeSocket(conn, feedMessage(msg)); err != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(sseHeartbeat)
+	defer ping.Stop()
+	var resyncs resyncTracker
+
+	for {
+		var err error
+		select {
+		case <-readerDone:
+			// Client closed the socket or stopped answering pings
+			return
+		case msg := <-sub.C:
+			err = writeSocket(conn, feedMessage(msg))
+		case reply := <-replies:
+			err = writeSocket(conn, reply)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(sseHeartbeat))
+		case <-sub.Lagged:
+			if resyncs.tooSlow(time.Now()) {
+				log.Printf("disconnecting slow WebSocket client of event %d", eventID)
+				writeSocket(conn, resyncMessage("client too slow; reconnect later"))
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow"),
+					time.Now().Add(time.Second))
+				return
+			}
+
+			// Start over on a fresh subscription that begins with a snapshot
+			sseManager.Unsubscribe(eventID, sub)
+			sub, initial, err = seatFeed.Subscribe(eventID, "")
+			if err != nil {
+				return
+			}
+			err = writeSocket(conn, resyncMessage("client fell behind"))
+			for _, msg := range initial {
+				if err == nil {
+					err = writeSocket(conn, feedMessage(msg))
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readSeatCommands runs the commands of one socket until it is closed or
+// the client stops answering pings.
+func readSeatCommands(conn *websocket.Conn, r *http.Request, eventID int64, replies chan<- wsMessage, quit <-chan struct{}) {
+	pongWait := 2 * sseHeartbeat
+	conn.SetReadLimit(wsMaxCommandSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var reply wsMessage
+		var cmd wsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			body := invalidRequest("invalid command").body()
+			reply = wsMessage{Type: "error", Error: &body}
+		} else {
+			reply = runSeatCommand(r, eventID, cmd)
+		}
+
+		select {
+		case replies <- reply:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// runSeatCommand executes one reserve or release command for the socket's user.
+func runSeatCommand(r *http.Request, eventID int64, cmd wsCommand) wsMessage {
+	fail := func(err error) wsMessage {
+		body := apiError(err).body()
+		return wsMessage{Type: "error", RequestID: cmd.RequestID, Error: &body}
+	}
+
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		return fail(ErrMissingToken)
+	}
+	// Only seats of the event this socket watches can be touched
+	seat := getSeatByID(cmd.SeatID)
+	if seat == nil || seat.EventID != eventID {
+		return fail(ErrSeatNotFound)
+	}
+
+	var res *Reservation
+	var err error
+	switch cmd.Type {
+	case "reserve":
+		res, err = store.ReserveSeat(cmd.SeatID, userID, holdDuration(cmd.Duration))
+	case "release":
+		res, err = store.ReleaseSeat(cmd.SeatID, userID)
+	default:
+		return fail(invalidRequest(fmt.Sprintf("unknown command %q", cmd.Type)))
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	publishSeat(cmd.SeatID)
+	return wsMessage{Type: "result", RequestID: cmd.RequestID, Reservation: res}
+}
+
+// feedMessage wraps a seat feed message for the socket.
+func feedMessage(msg SSEMessage) wsMessage {
+	return wsMessage{Type: msg.Event, ID: msg.ID, Data: json.RawMessage(msg.Data)}
+}
+
+func resyncMessage(reason string) wsMessage {
+	data, _ := json.Marshal(map[string]string{"reason": reason})
+	return wsMessage{Type: "resync", Data: data}
+}
+
+// writeSocket sends one JSON message, giving up if the client stalls.
+func writeSocket(conn *websocket.Conn, msg wsMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(sseHeartbeat))
+	return conn.WriteJSON(msg)
+}
@@ -2,6 +2,33 @@
 
 const API_BASE_URL = "http://localhost:8080"; // adjust as needed
 
+// Bearer token for reserve/book calls. Print one with
+// `go run . -issue-token <userID>` in seatmap/backend.
+function authHeaders() {
+  const token = process.env.REACT_APP_AUTH_TOKEN || localStorage.getItem("authToken");
+  return token ? { Authorization: `Bearer ${token}` } : {};
+}
+
+// apiError turns an error response ({"error": {"code", "message"}}) into an
+// Error whose code can be checked, e.g. err.code === "SEAT_NOT_AVAILABLE".
+async function apiError(res) {
+  let body;
+  try {
+    body = await res.json();
+  } catch {
+    body = null;
+  }
+  const err = new Error(body?.error?.message || `request failed with status ${res.status}`);
+  err.code = body?.error?.code || "UNKNOWN";
+  err.status = res.status;
+  err.details = body?.error?.details;
+  return err;
+}
+
+export function compareSeats(a, b) {
+  return (a.section || "").localeCompare(b.section || "") || a.row - b.row || a.number - b.number;
+}
+
 export async function getSeatsByEvent(eventID) {
   console.log("getSeatsByEvent", eventID);
   const res = await fetch(`${API_BASE_URL}/events/${eventID}/seats`);
@@ -9,8 +36,8 @@ export async function getSeatsByEvent(eventID) {
     throw new Error("Failed to fetch seats");
   }
   const seats = await res.json();
-  // sort seats by seatRow and seatNumber
-  seats.sort((a, b) => a.row - b.row || a.number - b.number);
+  // sort seats by section, row and number
+  seats.sort(compareSeats);
   console.log("getSeatsByEvent", seats);
   return seats;
 }
@@ -19,9 +46,10 @@ export async function reserveSeat(seatID, duration = 300) {
   console.log("reserveSeat", seatID, duration);
   const res = await fetch(`${API_BASE_URL}/seats/${seatID}/reserve?duration=${duration}`, {
     method: "POST",
+    headers: authHeaders(),
   });
   if (!res.ok) {
-    throw new Error(await res.text());
+    throw await apiError(res);
   }
   return res.text();
 }
@@ -30,9 +58,23 @@ export async function bookSeat(seatID) {
   console.log("bookSeat", seatID);
   const res = await fetch(`${API_BASE_URL}/seats/${seatID}/book`, {
     method: "POST",
+    headers: authHeaders(),
   });
   if (!res.ok) {
-    throw new Error(await res.text());
+    throw await apiError(res);
   }
   return res.text();
 }
+
+export async function bestAvailable(eventID, quantity, duration = 300) {
+  console.log("bestAvailable", eventID, quantity);
+  const res = await fetch(`${API_BASE_URL}/events/${eventID}/best-available`, {
+    method: "POST",
+    headers: { ...authHeaders(), "Content-Type": "application/json" },
+    body: JSON.stringify({ quantity, duration }),
+  });
+  if (!res.ok) {
+    throw await apiError(res);
+  }
+  return res.json();
+}
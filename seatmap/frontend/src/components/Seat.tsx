@@ -1,7 +1,7 @@
 // src/components/Seat.js
 import React from "react";
 
-function Seat({ seat, onReserve, onBook }) {
+function Seat({ seat, position, onReserve, onBook }) {
   // Choose color/style based on seat status
   let bgColor = "#fff";
   if (seat.status === "available") bgColor = "green";
@@ -10,7 +10,9 @@ function Seat({ seat, onReserve, onBook }) {
 
   return (
     <div
+      title={seatTitle(seat)}
       style={{
+        ...(position ? { position: "absolute", ...position } : {}),
         width: "40px",
         height: "40px",
         margin: "5px",
@@ -19,11 +21,12 @@ function Seat({ seat, onReserve, onBook }) {
         justifyContent: "center",
         alignItems: "center",
         cursor: seat.status === "available" ? "pointer" : "default",
-        border: "1px solid #000",
+        border: seat.obstructed_view ? "1px dashed #000" : "1px solid #000",
       }}
     >
       <div style={{ fontSize: "0.8rem", textAlign: "center" }}>
-        {seat.seatRow}-{seat.seatNumber}
+        {seat.accessible && "♿"}
+        {seat.row}-{seat.number}
       </div>
       {seat.status === "available" && (
         <button 
@@ -43,4 +46,13 @@ function Seat({ seat, onReserve, onBook }) {
   );
 }
 
+function seatTitle(seat) {
+  const parts = [`${seat.section ? seat.section + " " : ""}Row ${seat.row} Seat ${seat.number}`];
+  if (seat.accessible) parts.push("wheelchair space");
+  if (seat.obstructed_view) parts.push("obstructed view");
+  if (seat.aisle) parts.push("aisle");
+  if (seat.price_cents) parts.push(`$${(seat.price_cents / 100).toFixed(2)}`);
+  return parts.join(", ");
+}
+
 export default Seat;
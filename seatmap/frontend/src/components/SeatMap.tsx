@@ -1,12 +1,13 @@
 // src/SeatMap.js
 import React, { useEffect, useState } from "react";
-import { getSeatsByEvent, reserveSeat, bookSeat } from "../api.ts";
+import { getSeatsByEvent, reserveSeat, bookSeat, bestAvailable, compareSeats } from "../api.ts";
 import Seat from "./Seat.tsx";
 
 function SeatMap({ eventId }) {
   console.log("SeatMap", eventId);
   const [seats, setSeats] = useState([]);
   const [error, setError] = useState("");
+  const [quantity, setQuantity] = useState(2);
 
   // 1. Load seats initially
   useEffect(() => {
@@ -29,19 +30,44 @@ function SeatMap({ eventId }) {
     const sseUrl = `http://localhost:8080/events/${eventId}/seats/stream`;
     const eventSource = new EventSource(sseUrl);
 
-    // Whenever the server pushes a new seat map
-    eventSource.onmessage = (event) => {
+    // The stream starts with a snapshot of all seats...
+    eventSource.addEventListener("snapshot", (event) => {
       try {
-        console.log("SSE message", event.data);
-        const updatedSeats = JSON.parse(event.data);
-        console.log("updatedSeats", updatedSeats);
-        // sort seats by seatRow and seatNumber
-        updatedSeats.sort((a, b) => a.row - b.row || a.number - b.number);
-        setSeats(updatedSeats);
+        const snapshot = JSON.parse((event as MessageEvent).data);
+        console.log("SSE snapshot", snapshot.version);
+        // sort seats by section, row and number
+        snapshot.seats.sort(compareSeats);
+        setSeats(snapshot.seats);
       } catch (err) {
-        console.error("Failed to parse SSE data:", err);
+        console.error("Failed to parse SSE snapshot:", err);
       }
-    };
+    });
+
+    // ...followed by one event per changed seat. On reconnect the browser
+    // sends the last id it saw and the server replays what was missed.
+    eventSource.addEventListener("seat", (event) => {
+      try {
+        const change = JSON.parse((event as MessageEvent).data);
+        console.log("SSE seat change", change);
+        setSeats((prev) => {
+          const others = prev.filter((s) => s.id !== change.seat_id);
+          if (change.removed) {
+            return others;
+          }
+          return [...others, change.seat].sort(compareSeats);
+        });
+      } catch (err) {
+        console.error("Failed to parse SSE seat change:", err);
+      }
+    });
+
+    // The server fell behind on our updates. It either follows up with a new
+    // snapshot or closes the stream, so refetch meanwhile to avoid showing
+    // a stale map.
+    eventSource.addEventListener("resync", (event) => {
+      console.warn("SSE resync", (event as MessageEvent).data);
+      fetchSeats();
+    });
 
     // For error handling
     eventSource.onerror = (err) => {
@@ -80,16 +106,40 @@ function SeatMap({ eventId }) {
     }
   }
 
+  // 5. Hold the best block of adjacent seats
+  async function handleBestAvailable() {
+    try {
+      setError("");
+      await bestAvailable(eventId, quantity);
+      // The held seats arrive through SSE like any other change
+    } catch (err) {
+      setError(err.message);
+    }
+  }
+
   return (
     <div>
       <h2>Seat Map (Event {eventId})</h2>
       {error && <p style={{ color: "red" }}>{error}</p>}
 
-      <div style={{ display: "flex", flexWrap: "wrap", maxWidth: "400px" }}>
+      <div style={{ marginBottom: "12px" }}>
+        <input
+          type="number"
+          min={1}
+          max={10}
+          value={quantity}
+          onChange={(e) => setQuantity(Number(e.target.value))}
+          style={{ width: "48px" }}
+        />
+        <button onClick={handleBestAvailable}>Best available</button>
+      </div>
+
+      <div style={hallStyle(seats)}>
         {seats.map((seat) => (
           <Seat
             key={seat.id}
             seat={seat}
+            position={seatPosition(seats, seat)}
             onReserve={handleReserve}
             onBook={handleBook}
           />
@@ -99,4 +149,47 @@ function SeatMap({ eventId }) {
   );
 }
 
+// Seats carry x/y coordinates from the venue layout (one unit per seat).
+// Draw them at those positions, falling back to a simple wrap when no seat
+// has coordinates.
+const SEAT_UNIT = 50;
+
+function hasCoordinates(seats) {
+  return seats.some((seat) => seat.x || seat.y);
+}
+
+function bounds(seats) {
+  const xs = seats.map((seat) => seat.x);
+  const ys = seats.map((seat) => seat.y);
+  return {
+    minX: Math.min(...xs),
+    minY: Math.min(...ys),
+    maxX: Math.max(...xs),
+    maxY: Math.max(...ys),
+  };
+}
+
+function hallStyle(seats) {
+  if (!hasCoordinates(seats)) {
+    return { display: "flex", flexWrap: "wrap", maxWidth: "400px" };
+  }
+  const { minX, minY, maxX, maxY } = bounds(seats);
+  return {
+    position: "relative",
+    width: `${(maxX - minX + 1) * SEAT_UNIT}px`,
+    height: `${(maxY - minY + 1) * SEAT_UNIT}px`,
+  };
+}
+
+function seatPosition(seats, seat) {
+  if (!hasCoordinates(seats)) {
+    return undefined;
+  }
+  const { minX, minY } = bounds(seats);
+  return {
+    left: `${(seat.x - minX) * SEAT_UNIT}px`,
+    top: `${(seat.y - minY) * SEAT_UNIT}px`,
+  };
+}
+
 export default SeatMap;